@@ -0,0 +1,105 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+)
+
+// UserAnonymization is the user_anonymization section of the configuration
+// file.
+type UserAnonymization struct {
+	// KeyFile holds the key of the HMAC replacing user names.
+	KeyFile string `yaml:"key_file"`
+	// Mapping replaces the listed user names, taking precedence over the
+	// HMAC. Each user needs a value of its own.
+	Mapping map[string]string `yaml:"mapping"`
+}
+
+// UserAnonymizer replaces user names in label values. A nil UserAnonymizer
+// keeps them as they are.
+type UserAnonymizer struct {
+	key     []byte
+	mapping map[string]string
+}
+
+// WithUserAnonymizer replaces the user names in all label values of the
+// exporter.
+func WithUserAnonymizer(a *UserAnonymizer) ExporterOpt {
+	return func(e *Exporter) {
+		e.users = a
+	}
+}
+
+// anonymizer returns the UserAnonymizer of the configuration, or nil if
+// anonymization is not configured.
+func (c UserAnonymization) anonymizer() (*UserAnonymizer, error) {
+	if c.KeyFile == "" && len(c.Mapping) == 0 {
+		return nil, nil
+	}
+	// Users sharing a label value would give rows of SHOW POOLS or SHOW USERS
+	// the same labels, failing the scrape. The HMAC keeps unmapped users
+	// apart, and the mapping must not merge users either.
+	if c.KeyFile == "" {
+		return nil, errors.New("key_file is required to replace users missing from the mapping")
+	}
+	mapped := make(map[string]string, len(c.Mapping))
+	for _, user := range slices.Sorted(maps.Keys(c.Mapping)) {
+		v := c.Mapping[user]
+		if other, ok := mapped[v]; ok {
+			return nil, fmt.Errorf("users %q and %q are both mapped to %q", other, user, v)
+		}
+		mapped[v] = user
+	}
+
+	key, err := os.ReadFile(c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %w", err)
+	}
+	key = bytes.TrimRight(key, "\r\n")
+	if len(key) == 0 {
+		return nil, errors.New("key file is empty")
+	}
+	return &UserAnonymizer{key: key, mapping: c.Mapping}, nil
+}
+
+// anonymize returns the replacement of a user name: its mapping if there is
+// one, else a truncated HMAC of the name.
+func (a *UserAnonymizer) anonymize(user string) string {
+	if a == nil || user == "" {
+		return user
+	}
+	if v, ok := a.mapping[user]; ok {
+		return v
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(user))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// userLabelColumns are the label columns holding user names in any namespace,
+// in addition to the user column given by filterColumns.
+var userLabelColumns = map[string]bool{
+	"user":       true,
+	"force_user": true,
+	"auth_user":  true,
+}
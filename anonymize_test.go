@@ -0,0 +1,180 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestUserAnonymizer(t *testing.T) {
+	keyed, err := UserAnonymization{
+		KeyFile: writeTestFile(t, "key", "s3cret\n"),
+		Mapping: map[string]string{"postgres": "admin"},
+	}.anonymizer()
+	if err != nil {
+		t.Fatalf("Error loading anonymizer: %s", err)
+	}
+
+	convey.Convey("Users are replaced by a stable keyed hash", t, func() {
+		// HMAC-SHA256 of "alice" with the key "s3cret", truncated.
+		convey.So(keyed.anonymize("alice"), convey.ShouldEqual, "765542af1f1d587b")
+		convey.So(keyed.anonymize("alice"), convey.ShouldEqual, keyed.anonymize("alice"))
+		convey.So(keyed.anonymize("bob"), convey.ShouldNotEqual, keyed.anonymize("alice"))
+	})
+
+	convey.Convey("The mapping takes precedence over the hash", t, func() {
+		convey.So(keyed.anonymize("postgres"), convey.ShouldEqual, "admin")
+	})
+
+	convey.Convey("Configurations merging users are rejected", t, func() {
+		_, err := UserAnonymization{Mapping: map[string]string{"alice": "team-a"}}.anonymizer()
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = UserAnonymization{
+			KeyFile: writeTestFile(t, "key", "s3cret\n"),
+			Mapping: map[string]string{"alice": "team-a", "bob": "team-a"},
+		}.anonymizer()
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("Without configuration users are kept", t, func() {
+		a, err := UserAnonymization{}.anonymizer()
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(a.anonymize("alice"), convey.ShouldEqual, "alice")
+	})
+}
+
+func TestAnonymizedUserLabels(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW databases;").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "host", "port", "database", "force_user", "pool_size"}).
+			AddRow("pg0", "10.0.0.1", "5432", "pg0", "alice", 20))
+	mock.ExpectQuery("SHOW users;").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "pool_mode", "current_connections"}).
+			AddRow("alice", "", 5))
+	mock.ExpectQuery("SHOW CLIENTS;").WillReturnRows(
+		sqlmock.NewRows([]string{"type", "user", "database", "state", "application_name"}).
+			AddRow("C", "alice", "pg0", "active", "web"))
+
+	users, err := UserAnonymization{
+		KeyFile: writeTestFile(t, "key", "s3cret\n"),
+		Mapping: map[string]string{"alice": "team-a"},
+	}.anonymizer()
+	if err != nil {
+		t.Fatalf("Error loading anonymizer: %s", err)
+	}
+	logger := slog.Default()
+	metricMap := makeDescMap(metricMaps, namespace, logger)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		for _, ns := range []string{"databases", "users"} {
+			if _, err := queryNamespaceMapping(context.Background(), ch, db, ns, metricMap[ns], bouncerVersion{}, LabelFilter{}, users, logger); err != nil {
+				t.Errorf("Error running queryNamespaceMapping: %s", err)
+			}
+		}
+		if _, err := queryShowClients(context.Background(), ch, db, ClientOptions{users: users}, logger); err != nil {
+			t.Errorf("Error running queryShowClients: %s", err)
+		}
+	}()
+
+	userLabels := map[string][]string{}
+	for m := range ch {
+		r := readMetric(m)
+		for _, label := range []string{"user", "force_user"} {
+			if v, ok := r.labels[label]; ok {
+				userLabels[label] = append(userLabels[label], v)
+			}
+		}
+		if strings.Contains(m.Desc().String(), `"pgbouncer_users_`) {
+			userLabels["users.name"] = append(userLabels["users.name"], r.labels["name"])
+		}
+	}
+
+	convey.Convey("User labels of all collectors are anonymized", t, func() {
+		convey.So(userLabels, convey.ShouldHaveLength, 3)
+		for _, values := range userLabels {
+			for _, v := range values {
+				convey.So(v, convey.ShouldEqual, "team-a")
+			}
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// gatherFunc is an unchecked collector, so that Gather reports metrics with
+// the same labels as PgBouncer exporter's registry does.
+type gatherFunc func(ch chan<- prometheus.Metric)
+
+func (f gatherFunc) Describe(chan<- *prometheus.Desc) {}
+
+func (f gatherFunc) Collect(ch chan<- prometheus.Metric) { f(ch) }
+
+func TestAnonymizedUnmappedUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW pools;").WillReturnRows(
+		sqlmock.NewRows([]string{"database", "user", "cl_active"}).
+			AddRow("db1", "alice", 1).
+			AddRow("db1", "bob", 2))
+
+	users, err := UserAnonymization{
+		KeyFile: writeTestFile(t, "key", "s3cret\n"),
+		Mapping: map[string]string{"carol": "c"},
+	}.anonymizer()
+	if err != nil {
+		t.Fatalf("Error loading anonymizer: %s", err)
+	}
+	logger := slog.Default()
+	metricMap := makeDescMap(metricMaps, namespace, logger)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(gatherFunc(func(ch chan<- prometheus.Metric) {
+		if _, err := queryNamespaceMapping(context.Background(), ch, db, "pools", metricMap["pools"], bouncerVersion{}, LabelFilter{}, users, logger); err != nil {
+			t.Errorf("Error running queryNamespaceMapping: %s", err)
+		}
+	}))
+
+	convey.Convey("Unmapped users keep distinct labels", t, func() {
+		families, err := registry.Gather()
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(families, convey.ShouldHaveLength, 1)
+		metrics := families[0].GetMetric()
+		convey.So(metrics, convey.ShouldHaveLength, 2)
+		convey.So(metrics[0].GetLabel(), convey.ShouldNotResemble, metrics[1].GetLabel())
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
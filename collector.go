@@ -14,12 +14,19 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"math"
+	"net/netip"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 	"unicode/utf8"
 
@@ -31,50 +38,97 @@ import (
 var (
 	metricMaps = map[string]map[string]ColumnMapping{
 		"databases": {
-			"name":                {LABEL, "N/A", 1, "N/A"},
-			"host":                {LABEL, "N/A", 1, "N/A"},
-			"port":                {LABEL, "N/A", 1, "N/A"},
-			"database":            {LABEL, "N/A", 1, "N/A"},
-			"force_user":          {LABEL, "N/A", 1, "N/A"},
-			"pool_size":           {GAUGE, "pool_size", 1, "Maximum number of server connections"},
-			"reserve_pool":        {GAUGE, "reserve_pool", 1, "Maximum number of additional connections for this database"},
-			"reserve_pool_size":   {GAUGE, "reserve_pool", 1, "Maximum number of additional connections for this database"},
-			"pool_mode":           {LABEL, "N/A", 1, "N/A"},
-			"max_connections":     {GAUGE, "max_connections", 1, "Maximum number of allowed connections for this database"},
-			"current_connections": {GAUGE, "current_connections", 1, "Current number of connections for this database"},
-			"paused":              {GAUGE, "paused", 1, "1 if this database is currently paused, else 0"},
-			"disabled":            {GAUGE, "disabled", 1, "1 if this database is currently disabled, else 0"},
+			"name":                {LABEL, "N/A", 1, "N/A", nil},
+			"host":                {LABEL, "N/A", 1, "N/A", nil},
+			"port":                {LABEL, "N/A", 1, "N/A", nil},
+			"database":            {LABEL, "N/A", 1, "N/A", nil},
+			"force_user":          {LABEL, "N/A", 1, "N/A", nil},
+			"pool_size":           {GAUGE, "pool_size", 1, "Maximum number of server connections", nil},
+			"reserve_pool":        {GAUGE, "reserve_pool", 1, "Maximum number of additional connections for this database", nil},
+			"pool_mode":           {LABEL, "N/A", 1, "N/A", nil},
+			"max_connections":     {GAUGE, "max_connections", 1, "Maximum number of allowed connections for this database", nil},
+			"current_connections": {GAUGE, "current_connections", 1, "Current number of connections for this database", nil},
+			"paused":              {GAUGE, "paused", 1, "1 if this database is currently paused, else 0", nil},
+			"disabled":            {GAUGE, "disabled", 1, "1 if this database is currently disabled, else 0", nil},
 		},
 		"stats_totals": {
-			"database":                {LABEL, "N/A", 1, "N/A"},
-			"query_count":             {COUNTER, "queries_pooled_total", 1, "Total number of SQL queries pooled"},
-			"query_time":              {COUNTER, "queries_duration_seconds_total", 1e-6, "Total number of seconds spent by pgbouncer when actively connected to PostgreSQL, executing queries"},
-			"bytes_received":          {COUNTER, "received_bytes_total", 1, "Total volume in bytes of network traffic received by pgbouncer, shown as bytes"},
-			"requests":                {COUNTER, "queries_total", 1, "Total number of SQL requests pooled by pgbouncer, shown as requests"},
-			"bytes_sent":              {COUNTER, "sent_bytes_total", 1, "Total volume in bytes of network traffic sent by pgbouncer, shown as bytes"},
-			"wait_time":               {COUNTER, "client_wait_seconds_total", 1e-6, "Time spent by clients waiting for a server in seconds"},
-			"xact_count":              {COUNTER, "sql_transactions_pooled_total", 1, "Total number of SQL transactions pooled"},
-			"xact_time":               {COUNTER, "server_in_transaction_seconds_total", 1e-6, "Total number of seconds spent by pgbouncer when connected to PostgreSQL in a transaction, either idle in transaction or executing queries"},
-			"client_parse_count":      {COUNTER, "client_parses_total", 1, "Total number of prepared statement Parse messages received from clients"},
-			"server_parse_count":      {COUNTER, "server_parses_total", 1, "Total number of prepared statement Parse messages sent by pgbouncer to PostgreSQL"},
-			"bind_count":              {COUNTER, "binds_total", 1, "Total number of prepared statements readied for execution with a Bind message"},
-			"server_assignment_count": {COUNTER, "server_assignments_total", 1, "Total number of client connections which have been served since process start"},
+			"database":                {LABEL, "N/A", 1, "N/A", nil},
+			"query_count":             {COUNTER, "queries_pooled_total", 1, "Total number of SQL queries pooled", nil},
+			"query_time":              {COUNTER, "queries_duration_seconds_total", 1e-6, "Total number of seconds spent by pgbouncer when actively connected to PostgreSQL, executing queries", nil},
+			"bytes_received":          {COUNTER, "received_bytes_total", 1, "Total volume in bytes of network traffic received by pgbouncer, shown as bytes", nil},
+			"requests":                {COUNTER, "queries_total", 1, "Total number of SQL requests pooled by pgbouncer, shown as requests", nil},
+			"bytes_sent":              {COUNTER, "sent_bytes_total", 1, "Total volume in bytes of network traffic sent by pgbouncer, shown as bytes", nil},
+			"wait_time":               {COUNTER, "client_wait_seconds_total", 1e-6, "Time spent by clients waiting for a server in seconds", nil},
+			"xact_count":              {COUNTER, "sql_transactions_pooled_total", 1, "Total number of SQL transactions pooled", nil},
+			"xact_time":               {COUNTER, "server_in_transaction_seconds_total", 1e-6, "Total number of seconds spent by pgbouncer when connected to PostgreSQL in a transaction, either idle in transaction or executing queries", nil},
+			"client_parse_count":      {COUNTER, "client_parses_total", 1, "Total number of prepared statement Parse messages received from clients", nil},
+			"server_parse_count":      {COUNTER, "server_parses_total", 1, "Total number of prepared statement Parse messages sent by pgbouncer to PostgreSQL", nil},
+			"bind_count":              {COUNTER, "binds_total", 1, "Total number of prepared statements readied for execution with a Bind message", nil},
+			"server_assignment_count": {COUNTER, "server_assignments_total", 1, "Total number of client connections which have been served since process start", nil},
+		},
+		"stats_averages": {
+			"database":                {LABEL, "N/A", 1, "N/A", nil},
+			"xact_count":              {GAUGE, "sql_transactions_per_second", 1, "Average number of SQL transactions pooled per second in the last stats period", nil},
+			"query_count":             {GAUGE, "queries_per_second", 1, "Average number of SQL queries pooled per second in the last stats period", nil},
+			"bytes_received":          {GAUGE, "received_bytes_per_second", 1, "Average volume in bytes of network traffic received by pgbouncer per second in the last stats period", nil},
+			"bytes_sent":              {GAUGE, "sent_bytes_per_second", 1, "Average volume in bytes of network traffic sent by pgbouncer per second in the last stats period", nil},
+			"xact_time":               {GAUGE, "transaction_duration_seconds", 1e-6, "Average transaction duration in seconds in the last stats period", nil},
+			"query_time":              {GAUGE, "query_duration_seconds", 1e-6, "Average query duration in seconds in the last stats period", nil},
+			"wait_time":               {GAUGE, "client_wait_seconds", 1e-6, "Average time spent by clients waiting for a server in seconds in the last stats period", nil},
+			"client_parse_count":      {GAUGE, "client_parses_per_second", 1, "Average number of prepared statement Parse messages received from clients per second in the last stats period", nil},
+			"server_parse_count":      {GAUGE, "server_parses_per_second", 1, "Average number of prepared statement Parse messages sent by pgbouncer to PostgreSQL per second in the last stats period", nil},
+			"bind_count":              {GAUGE, "binds_per_second", 1, "Average number of prepared statements readied for execution with a Bind message per second in the last stats period", nil},
+			"server_assignment_count": {GAUGE, "server_assignments_per_second", 1, "Average number of client connections served per second in the last stats period", nil},
+		},
+		"users": {
+			"name":                        {LABEL, "N/A", 1, "N/A", nil},
+			"pool_mode":                   {LABEL, "N/A", 1, "N/A", nil},
+			"pool_size":                   {GAUGE, "pool_size", 1, "Maximum number of server connections per pool of this user", nil},
+			"reserve_pool_size":           {GAUGE, "reserve_pool", 1, "Maximum number of additional server connections per pool of this user", nil},
+			"max_user_connections":        {GAUGE, "max_connections", 1, "Maximum number of server connections for this user", nil},
+			"current_connections":         {GAUGE, "current_connections", 1, "Current number of server connections for this user", nil},
+			"max_user_client_connections": {GAUGE, "max_client_connections", 1, "Maximum number of client connections for this user", nil},
+			"current_client_connections":  {GAUGE, "current_client_connections", 1, "Current number of client connections for this user", nil},
 		},
 		"pools": {
-			"database":              {LABEL, "N/A", 1, "N/A"},
-			"user":                  {LABEL, "N/A", 1, "N/A"},
-			"cl_active":             {GAUGE, "client_active_connections", 1, "Client connections linked to server connection and able to process queries, shown as connection"},
-			"cl_active_cancel_req":  {GAUGE, "client_active_cancel_connections", 1, "Client connections that have forwarded query cancellations to the server and are waiting for the server response"},
-			"cl_waiting":            {GAUGE, "client_waiting_connections", 1, "Client connections waiting on a server connection, shown as connection"},
-			"cl_waiting_cancel_req": {GAUGE, "client_waiting_cancel_connections", 1, "Client connections that have not forwarded query cancellations to the server yet"},
-			"sv_active":             {GAUGE, "server_active_connections", 1, "Server connections linked to a client connection, shown as connection"},
-			"sv_active_cancel":      {GAUGE, "server_active_cancel_connections", 1, "Server connections that are currently forwarding a cancel request."},
-			"sv_being_canceled":     {GAUGE, "server_being_canceled_connections", 1, "Servers that normally could become idle but are waiting to do so until all in-flight cancel requests have completed that were sent to cancel a query on this server."},
-			"sv_idle":               {GAUGE, "server_idle_connections", 1, "Server connections idle and ready for a client query, shown as connection"},
-			"sv_used":               {GAUGE, "server_used_connections", 1, "Server connections idle more than server_check_delay, needing server_check_query, shown as connection"},
-			"sv_tested":             {GAUGE, "server_testing_connections", 1, "Server connections currently running either server_reset_query or server_check_query, shown as connection"},
-			"sv_login":              {GAUGE, "server_login_connections", 1, "Server connections currently in the process of logging in, shown as connection"},
-			"maxwait":               {GAUGE, "client_maxwait_seconds", 1, "Age of oldest unserved client connection, shown as second"},
+			"database":              {LABEL, "N/A", 1, "N/A", nil},
+			"user":                  {LABEL, "N/A", 1, "N/A", nil},
+			"cl_active":             {GAUGE, "client_active_connections", 1, "Client connections linked to server connection and able to process queries, shown as connection", nil},
+			"cl_active_cancel_req":  {GAUGE, "client_active_cancel_connections", 1, "Client connections that have forwarded query cancellations to the server and are waiting for the server response", nil},
+			"cl_waiting":            {GAUGE, "client_waiting_connections", 1, "Client connections waiting on a server connection, shown as connection", nil},
+			"cl_waiting_cancel_req": {GAUGE, "client_waiting_cancel_connections", 1, "Client connections that have not forwarded query cancellations to the server yet", nil},
+			"sv_active":             {GAUGE, "server_active_connections", 1, "Server connections linked to a client connection, shown as connection", nil},
+			"sv_active_cancel":      {GAUGE, "server_active_cancel_connections", 1, "Server connections that are currently forwarding a cancel request.", nil},
+			"sv_being_canceled":     {GAUGE, "server_being_canceled_connections", 1, "Servers that normally could become idle but are waiting to do so until all in-flight cancel requests have completed that were sent to cancel a query on this server.", nil},
+			"sv_idle":               {GAUGE, "server_idle_connections", 1, "Server connections idle and ready for a client query, shown as connection", nil},
+			"sv_used":               {GAUGE, "server_used_connections", 1, "Server connections idle more than server_check_delay, needing server_check_query, shown as connection", nil},
+			"sv_tested":             {GAUGE, "server_testing_connections", 1, "Server connections currently running either server_reset_query or server_check_query, shown as connection", nil},
+			"sv_login":              {GAUGE, "server_login_connections", 1, "Server connections currently in the process of logging in, shown as connection", nil},
+			"maxwait":               {GAUGE, "client_maxwait_seconds", 1, "Age of oldest unserved client connection, shown as second", nil},
+			"pool_mode":             {MAPPEDMETRIC, "pool_mode", 1, "Pool mode of the pool, 0 for session, 1 for transaction and 2 for statement", map[string]float64{"session": 0, "transaction": 1, "statement": 2}},
+		},
+	}
+
+	// Ratios derived from the columns of a namespace. A row is skipped when
+	// the denominator is zero, which PgBouncer uses for "no limit".
+	ratioMaps = map[string][]RatioMapping{
+		"users": {
+			{"current_connections", "max_user_connections", "connection_utilization_ratio", "Ratio of current to maximum server connections for this user"},
+			{"current_client_connections", "max_user_client_connections", "client_connection_utilization_ratio", "Ratio of current to maximum client connections for this user"},
+		},
+	}
+
+	// Columns renamed or split by a PgBouncer release. A rewrite applies when
+	// the version of PgBouncer is in its range and all its source columns are
+	// returned, the source columns are then not mapped on their own.
+	columnRewrites = map[string][]ColumnRewrite{
+		"databases": {
+			// Renamed in 1.24.
+			{versionRange{since: bouncerVersion{1, 24, 0}}, "reserve_pool", []string{"reserve_pool_size"}, []float64{1}},
+		},
+		"pools": {
+			// maxwait only holds whole seconds, maxwait_us the microseconds.
+			{versionRange{since: bouncerVersion{1, 8, 0}}, "maxwait", []string{"maxwait", "maxwait_us"}, []float64{1, 1e-6}},
 		},
 	}
 
@@ -114,6 +168,33 @@ var (
 			"Count of in-flight DNS queries", nil, nil),
 	}
 
+	memMap = map[string]*(prometheus.Desc){
+		"size": prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "mem", "item_size_bytes"),
+			"Size of a single item in the memory cache", []string{"cache"}, nil),
+		"used": prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "mem", "used_items"),
+			"Count of used items in the memory cache", []string{"cache"}, nil),
+		"free": prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "mem", "free_items"),
+			"Count of free items in the memory cache", []string{"cache"}, nil),
+		"memtotal": prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "mem", "total_bytes"),
+			"Total bytes allocated by the memory cache", []string{"cache"}, nil),
+	}
+
+	stateMap = map[string]*(prometheus.Desc){
+		"active": prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "state", "active"),
+			"1 if the pgbouncer process is active, else 0", nil, nil),
+		"paused": prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "state", "paused"),
+			"1 if the pgbouncer process is paused, else 0", nil, nil),
+		"suspended": prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "state", "suspended"),
+			"1 if the pgbouncer process is suspended, else 0", nil, nil),
+	}
+
 	configMap = map[string]*(prometheus.Desc){
 		"max_client_conn": prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "config", "max_client_connections"),
@@ -130,6 +211,41 @@ var (
 		"Number of client connections grouped by database, user, application name, and state",
 		[]string{"database", "user", "application_name", "state"}, nil,
 	)
+	clientFoldedSeriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "client", "folded_series"),
+		"Number of series of client metrics folded into \"other\" by the cardinality limits of the label",
+		[]string{"label"}, nil,
+	)
+	clientSourceConnectionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "client", "source_connections"),
+		"Number of client connections grouped by database and source network",
+		[]string{"database", "source"}, nil,
+	)
+	clientWaitDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "client", "wait_seconds"),
+		"Current waiting time of client connections in seconds",
+		[]string{"database", "user"}, nil,
+	)
+	clientAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "client", "connection_age_seconds"),
+		"Age of client connections in seconds",
+		[]string{"database", "user"}, nil,
+	)
+	serverConnectionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "server", "connections"),
+		"Number of server connections grouped by database, user, backend address, backend port, and state",
+		[]string{"database", "user", "addr", "port", "state"}, nil,
+	)
+	serverOldestConnectionAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "server", "oldest_connection_age_seconds"),
+		"Age of the oldest server connection of the pool",
+		[]string{"database", "user"}, nil,
+	)
+	serverOldestRequestAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "server", "oldest_request_age_seconds"),
+		"Age of the oldest last request on a server connection of the pool",
+		[]string{"database", "user"}, nil,
+	)
 )
 
 // Metric descriptors.
@@ -141,28 +257,182 @@ var (
 	)
 	scrapeSuccessDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "up"),
-		"The pgbouncer scrape succeeded",
+		"Whether the pgbouncer admin console is reachable",
+		nil, nil,
+	)
+	collectorSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "collector_success"),
+		"Whether the collector succeeded",
+		[]string{"collector"}, nil,
+	)
+	collectorTimeoutDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "collector_timeout"),
+		"Whether the collector was cut off by the scrape timeout",
+		[]string{"collector"}, nil,
+	)
+	collectorDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "collector_duration_seconds"),
+		"Duration of the collector in seconds",
+		[]string{"collector"}, nil,
+	)
+	scrapeDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "scrape_duration_seconds"),
+		"Duration of the pgbouncer scrape in seconds",
+		nil, nil,
+	)
+	reconnectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "reconnects_total"),
+		"Number of times the connection to the pgbouncer admin console was re-established",
+		nil, nil,
+	)
+	connectionAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "connection_age_seconds"),
+		"Age of the connection to the pgbouncer admin console in seconds",
+		nil, nil,
+	)
+	scrapeCachedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "scrape_cached"),
+		"Whether the response was served from the last successful scrape",
+		nil, nil,
+	)
+	lastSuccessfulScrapeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "last_successful_scrape_timestamp_seconds"),
+		"Time of the last scrape with a reachable pgbouncer admin console",
+		nil, nil,
+	)
+	scrapeSharedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "scrape_shared"),
+		"Whether the response was served from a scrape shared with other requests",
 		nil, nil,
 	)
 )
 
-func NewExporter(connectionString string, namespace string, logger *slog.Logger) *Exporter {
-	conn, err := pq.NewConnector(connectionString)
+// Upper bounds of the client histogram buckets.
+var (
+	clientWaitBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+	clientAgeBuckets  = []float64{1, 10, 60, 300, 900, 1800, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 24 * 3600, 7 * 24 * 3600}
+)
+
+// ClientOptions configures the clients collector.
+type ClientOptions struct {
+	// Histograms enables the wait time and connection age histograms.
+	Histograms bool
+	// MaxApplicationNames and MaxUsers cap the number of distinct label
+	// values, folding all but the values with the most clients into "other".
+	// Zero means no limit.
+	MaxApplicationNames int
+	MaxUsers            int
+	// ApplicationNameAllow and ApplicationNameDeny fold application names
+	// not matching, respectively matching, into "other" when set.
+	ApplicationNameAllow *regexp.Regexp
+	ApplicationNameDeny  *regexp.Regexp
+	// Rewrites normalize application_name and user values before the
+	// limits apply.
+	Rewrites []RewriteRule
+
+	// SourcePrefixV4 and SourcePrefixV6 are the prefix lengths client
+	// addresses are collapsed to by the client_sources collector.
+	SourcePrefixV4 int
+	SourcePrefixV6 int
+	// MaxSources caps the number of sources like MaxApplicationNames.
+	MaxSources int
+
+	// ownApplicationName is the application_name of the exporter's own
+	// connections, which are not counted.
+	ownApplicationName string
+	// filter is the database and user filter of the exporter.
+	filter LabelFilter
+	// users anonymizes the user label.
+	users *UserAnonymizer
+}
+
+// otherLabelValue replaces label values folded by the cardinality limits.
+const otherLabelValue = "other"
+
+// WithClientOptions configures the clients collector.
+func WithClientOptions(o ClientOptions) ExporterOpt {
+	return func(e *Exporter) {
+		e.clientOptions = o
+	}
+}
+
+// exporterApplicationName is the application_name of the admin console
+// connection, unless the connection string sets one.
+const exporterApplicationName = "pgbouncer_exporter"
+
+// ExporterOpt configures an Exporter.
+type ExporterOpt func(*Exporter)
+
+// WithColumnMappings replaces the built-in column mappings of the exporter.
+func WithColumnMappings(m map[string]map[string]ColumnMapping) ExporterOpt {
+	return func(e *Exporter) {
+		e.columnMappings = m
+	}
+}
+
+// WithCoalescing makes concurrent scrapes share a single round of queries, and
+// reuses its result for scrapes within window after it. A zero window
+// disables coalescing.
+func WithCoalescing(window time.Duration) ExporterOpt {
+	return func(e *Exporter) {
+		e.coalesceWindow = window
+	}
+}
+
+func NewExporter(connectionString string, namespace string, logger *slog.Logger, opts ...ExporterOpt) *Exporter {
+	cfg, err := pq.NewConfig(connectionString)
+	if err != nil {
+		logger.Error("failed to parse connection string", "error", err)
+		return nil
+	}
+
+	e, err := newExporter(cfg, namespace, logger, opts...)
 	if err != nil {
 		logger.Error("failed to create connector", "error", err)
 		return nil
 	}
+	return e
+}
+
+func newExporter(cfg pq.Config, namespace string, logger *slog.Logger, opts ...ExporterOpt) (*Exporter, error) {
+	// Name the connection, so that it can be told apart in SHOW CLIENTS.
+	if cfg.ApplicationName == "" {
+		cfg.ApplicationName = exporterApplicationName
+	}
+	conn, err := pq.NewConnectorConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	bc := newBackoffConnector(conn, logger)
+	e := &Exporter{
+		conn:           bc,
+		db:             openDB(bc),
+		columnMappings: metricMaps,
+		logger:         logger,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.clientOptions.ownApplicationName = cfg.ApplicationName
+	e.clientOptions.filter = e.filter
+	e.clientOptions.users = e.users
+	e.metricMap = makeDescMap(e.columnMappings, namespace, logger)
+	e.collectors = newCollectors(e)
+	return e, nil
+}
 
-	return &Exporter{
-		conn:      conn,
-		metricMap: makeDescMap(metricMaps, namespace, logger),
-		logger:    logger,
+// Close closes the connection to the admin console.
+func (e *Exporter) Close() error {
+	if e.sampler != nil {
+		e.sampler.stop()
 	}
+	return e.db.Close()
 }
 
 // Query SHOW LISTS, which has a series of rows, not columns.
-func queryShowLists(ch chan<- prometheus.Metric, db *sql.DB, logger *slog.Logger) error {
-	rows, err := db.Query("SHOW LISTS;")
+func queryShowLists(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB, ownConnections float64, logger *slog.Logger) error {
+	rows, err := db.QueryContext(ctx, "SHOW LISTS;")
 	if err != nil {
 		return fmt.Errorf("error running SHOW LISTS on database: %w", err)
 	}
@@ -183,6 +453,10 @@ func queryShowLists(ch chan<- prometheus.Metric, db *sql.DB, logger *slog.Logger
 		if err != nil {
 			return fmt.Errorf("error parsing SHOW LISTS column: %v, error: %w", list, err)
 		}
+		// Match the client metrics, which leave out the exporter itself.
+		if list == "used_clients" {
+			value = math.Max(value-ownConnections, 0)
+		}
 		if metric, ok := listsMap[list]; ok {
 			ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, value)
 		} else {
@@ -192,9 +466,112 @@ func queryShowLists(ch chan<- prometheus.Metric, db *sql.DB, logger *slog.Logger
 	return nil
 }
 
+// Query SHOW MEM, which has a row for each internal memory cache.
+func queryShowMem(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB, logger *slog.Logger) error {
+	rows, err := db.QueryContext(ctx, "SHOW MEM;")
+	if err != nil {
+		return fmt.Errorf("error running SHOW MEM on database: %w", err)
+	}
+	defer rows.Close()
+
+	columnNames, err := rows.Columns()
+	if err != nil || len(columnNames) == 0 || columnNames[0] != "name" {
+		return fmt.Errorf("error retrieving columns list from SHOW MEM: %w", err)
+	}
+
+	var cache string
+	values := make([]sql.RawBytes, len(columnNames)-1)
+	scanArgs := []any{&cache}
+	for i := range values {
+		scanArgs = append(scanArgs, &values[i])
+	}
+	for rows.Next() {
+		if err = rows.Scan(scanArgs...); err != nil {
+			return fmt.Errorf("error retrieving SHOW MEM rows: %w", err)
+		}
+		if !utf8.ValidString(cache) {
+			cache = "<invalid>"
+		}
+		for i, column := range columnNames[1:] {
+			metric, ok := memMap[column]
+			if !ok {
+				logger.Debug("SHOW MEM unknown column", "column", column)
+				continue
+			}
+			value, err := strconv.ParseFloat(string(values[i]), 64)
+			if err != nil {
+				return fmt.Errorf("error parsing SHOW MEM column: %v, cache: %v, error: %w", column, cache, err)
+			}
+			ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, value, cache)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating SHOW MEM rows: %w", err)
+	}
+	return nil
+}
+
+// Query SHOW STATE, which has a series of rows, not columns.
+func queryShowState(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB, logger *slog.Logger) error {
+	rows, err := db.QueryContext(ctx, "SHOW STATE;")
+	if err != nil {
+		return fmt.Errorf("error running SHOW STATE on database: %w", err)
+	}
+	defer rows.Close()
+
+	columnNames, err := rows.Columns()
+	if err != nil || len(columnNames) != 2 {
+		return fmt.Errorf("error retrieving columns list from SHOW STATE: %w", err)
+	}
+
+	var key string
+	var values sql.RawBytes
+	for rows.Next() {
+		if err = rows.Scan(&key, &values); err != nil {
+			return fmt.Errorf("error retrieving SHOW STATE rows: %w", err)
+		}
+		value, err := parseBouncerBool(string(values))
+		if err != nil {
+			return fmt.Errorf("error parsing SHOW STATE column: %v, error: %w", key, err)
+		}
+		if metric, ok := stateMap[key]; ok {
+			v := 0.0
+			if value {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, v)
+		} else {
+			logger.Debug("SHOW STATE unknown state", "state", key)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating SHOW STATE rows: %w", err)
+	}
+	return nil
+}
+
+// isBadShowArg returns whether PgBouncer rejected a SHOW command it does not
+// know.
+func isBadShowArg(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && strings.Contains(pqErr.Message, "bad SHOW arg")
+}
+
+// Parse a boolean as shown by the admin console.
+func parseBouncerBool(s string) (bool, error) {
+	switch s {
+	case "yes", "on", "true", "1":
+		return true, nil
+	case "no", "off", "false", "0":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid boolean %q", s)
+	}
+}
+
 // Query SHOW CONFIG, which has a series of rows, not columns.
-func queryShowConfig(ch chan<- prometheus.Metric, db *sql.DB, logger *slog.Logger) error {
-	rows, err := db.Query("SHOW CONFIG;")
+func queryShowConfig(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB, logger *slog.Logger) error {
+	rows, err := db.QueryContext(ctx, "SHOW CONFIG;")
 	if err != nil {
 		return fmt.Errorf("error running SHOW CONFIG on database: %w", err)
 	}
@@ -246,41 +623,420 @@ func queryShowConfig(ch chan<- prometheus.Metric, db *sql.DB, logger *slog.Logge
 	return nil
 }
 
-// Query SHOW CLIENTS, aggregate by (database, user, application_name, state), and emit counts.
-func queryShowClients(ch chan<- prometheus.Metric, db *sql.DB, _ *slog.Logger) error {
-	rows, err := db.Query("SHOW CLIENTS;")
+// clientRow holds the columns of a SHOW CLIENTS row used by the client
+// collectors. Columns missing from the output are empty.
+type clientRow struct {
+	database, user, state, applicationName, addr string
+	wait, waitUs, connectTime, requestTime       string
+}
+
+// clientRows holds the output of SHOW CLIENTS.
+type clientRows struct {
+	columns map[string]bool
+	rows    []clientRow
+}
+
+// readShowClients runs SHOW CLIENTS, or returns the rows read earlier in the
+// scrape, so that the client collectors share one query.
+func readShowClients(ctx context.Context, db *sql.DB) (*clientRows, error) {
+	state := scrapeStateFrom(ctx)
+	if c := state.clientRows(); c != nil {
+		return c, nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SHOW CLIENTS;")
 	if err != nil {
-		return fmt.Errorf("error running SHOW CLIENTS on database: %w", err)
+		return nil, fmt.Errorf("error running SHOW CLIENTS on database: %w", err)
 	}
 	defer rows.Close()
 
 	columnNames, err := rows.Columns()
 	if err != nil {
-		return fmt.Errorf("error retrieving columns from SHOW CLIENTS: %w", err)
+		return nil, fmt.Errorf("error retrieving columns from SHOW CLIENTS: %w", err)
 	}
 
+	c := &clientRows{columns: make(map[string]bool, len(columnNames))}
 	colIdx := make(map[string]int, len(columnNames))
+	values := make([]sql.RawBytes, len(columnNames))
+	scanArgs := make([]any, len(columnNames))
 	for i, name := range columnNames {
+		c.columns[name] = true
 		colIdx[name] = i
+		scanArgs[i] = &values[i]
+	}
+	col := func(name string) string {
+		if i, ok := colIdx[name]; ok {
+			return string(values[i])
+		}
+		return ""
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, fmt.Errorf("error scanning SHOW CLIENTS row: %w", err)
+		}
+		c.rows = append(c.rows, clientRow{
+			database:        col("database"),
+			user:            col("user"),
+			state:           col("state"),
+			applicationName: col("application_name"),
+			addr:            col("addr"),
+			wait:            col("wait"),
+			waitUs:          col("wait_us"),
+			connectTime:     col("connect_time"),
+			requestTime:     col("request_time"),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating SHOW CLIENTS rows: %w", err)
+	}
+	state.setClientRows(c)
+	return c, nil
+}
+
+// Query SHOW CLIENTS, aggregate by (database, user, application_name, state), and emit counts.
+// With histograms enabled, also emit the wait time and connection age of the clients per pool.
+// Returns the number of admin console connections of exporters, which are left out.
+func queryShowClients(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB, opts ClientOptions, logger *slog.Logger) (float64, error) {
+	clients, err := readShowClients(ctx, db)
+	if err != nil {
+		return 0, err
 	}
 
 	for _, required := range []string{"database", "user", "state"} {
-		if _, ok := colIdx[required]; !ok {
-			return fmt.Errorf("SHOW CLIENTS missing required column: %s", required)
+		if !clients.columns[required] {
+			return 0, fmt.Errorf("SHOW CLIENTS missing required column: %s", required)
 		}
 	}
 
 	type groupKey struct{ database, user, applicationName, state string }
 	counts := make(map[groupKey]float64)
 
+	type poolKey struct{ database, user string }
+	waitHistograms := make(map[poolKey]*constHistogram)
+	ageHistograms := make(map[poolKey]*constHistogram)
+
+	type connectTime struct {
+		pool poolKey
+		t    time.Time
+	}
+	var connectTimes []connectTime
+	var clock bouncerClock
+
+	var ownConnections float64
+
+	sanitize := func(s string) string {
+		if !utf8.ValidString(s) {
+			return "<invalid>"
+		}
+		return s
+	}
+
+	for _, row := range clients.rows {
+		clock.observe(row.requestTime)
+		if opts.isOwnConnection(row.database, row.applicationName) {
+			ownConnections++
+			continue
+		}
+		if !opts.filter.allowsDatabase(row.database) || !opts.filter.allowsUser(row.user) {
+			continue
+		}
+		key := groupKey{
+			database:        sanitize(row.database),
+			user:            sanitize(opts.users.anonymize(rewriteLabel(opts.Rewrites, "user", row.user))),
+			applicationName: sanitize(rewriteLabel(opts.Rewrites, "application_name", row.applicationName)),
+			state:           sanitize(row.state),
+		}
+		counts[key]++
+
+		if !opts.Histograms {
+			continue
+		}
+		pool := poolKey{database: key.database, user: key.user}
+		// wait holds whole seconds, wait_us the microseconds on top.
+		if wait, err := strconv.ParseFloat(row.wait, 64); err == nil {
+			if waitUs, err := strconv.ParseFloat(row.waitUs, 64); err == nil {
+				wait += waitUs / 1e6
+			}
+			observe(waitHistograms, pool, clientWaitBuckets, wait)
+		}
+		if row.connectTime != "" {
+			t, err := parseBouncerTime(row.connectTime)
+			if err != nil {
+				logger.Debug("SHOW CLIENTS unparsable time", "value", row.connectTime, "err", err)
+				continue
+			}
+			connectTimes = append(connectTimes, connectTime{pool: pool, t: t})
+		}
+	}
+	scrapeStateFrom(ctx).setClock(clock)
+
+	// Ages are measured once the clock of PgBouncer is known.
+	for _, c := range connectTimes {
+		observe(ageHistograms, c.pool, clientAgeBuckets, clock.since(c.t).Seconds())
+	}
+
+	// Fold the application names failing the allow and deny lists, then the
+	// label values with the fewest clients once over the limits. Values
+	// folded by the lists do not take a place in the limits.
+	appTotals := make(map[string]float64)
+	userTotals := make(map[string]float64)
+	for key, count := range counts {
+		if opts.applicationNameAllowed(key.applicationName) {
+			appTotals[key.applicationName] += count
+		}
+		userTotals[key.user] += count
+	}
+	keepApps := topLabelValues(appTotals, opts.MaxApplicationNames)
+	keepUsers := topLabelValues(userTotals, opts.MaxUsers)
+	var foldedAppSeries, foldedUserSeries float64
+	if opts.limitsLabels() {
+		folded := make(map[groupKey]float64, len(counts))
+		for key, count := range counts {
+			if !opts.applicationNameAllowed(key.applicationName) || keepApps != nil && !keepApps[key.applicationName] {
+				key.applicationName = otherLabelValue
+				foldedAppSeries++
+			}
+			if keepUsers != nil && !keepUsers[key.user] {
+				key.user = otherLabelValue
+				foldedUserSeries++
+			}
+			folded[key] += count
+		}
+		counts = folded
+	}
+	if keepUsers != nil {
+		for _, histograms := range []map[poolKey]*constHistogram{waitHistograms, ageHistograms} {
+			for pool, h := range histograms {
+				if !keepUsers[pool.user] {
+					delete(histograms, pool)
+					other := poolKey{database: pool.database, user: otherLabelValue}
+					if o, ok := histograms[other]; ok {
+						o.merge(h)
+					} else {
+						histograms[other] = h
+					}
+				}
+			}
+		}
+	}
+	if opts.limitsLabels() {
+		ch <- prometheus.MustNewConstMetric(clientFoldedSeriesDesc, prometheus.GaugeValue, foldedAppSeries, "application_name")
+		ch <- prometheus.MustNewConstMetric(clientFoldedSeriesDesc, prometheus.GaugeValue, foldedUserSeries, "user")
+	}
+
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(
+			clientConnectionsDesc,
+			prometheus.GaugeValue,
+			count,
+			key.database, key.user, key.applicationName, key.state,
+		)
+	}
+	for pool, h := range waitHistograms {
+		ch <- prometheus.MustNewConstHistogram(clientWaitDesc, h.count, h.sum, h.buckets, pool.database, pool.user)
+	}
+	for pool, h := range ageHistograms {
+		ch <- prometheus.MustNewConstHistogram(clientAgeDesc, h.count, h.sum, h.buckets, pool.database, pool.user)
+	}
+	return ownConnections, nil
+}
+
+// Query SHOW CLIENTS, aggregate by (database, source network), and emit counts.
+func queryShowClientSources(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB, opts ClientOptions, _ *slog.Logger) error {
+	clients, err := readShowClients(ctx, db)
+	if err != nil {
+		return err
+	}
+	if !clients.columns["database"] || !clients.columns["addr"] {
+		return errors.New("SHOW CLIENTS missing required column: database or addr")
+	}
+
+	type groupKey struct{ database, source string }
+	counts := make(map[groupKey]float64)
+	for _, row := range clients.rows {
+		if opts.isOwnConnection(row.database, row.applicationName) {
+			continue
+		}
+		if !opts.filter.allowsDatabase(row.database) || !opts.filter.allowsUser(row.user) {
+			continue
+		}
+		database := row.database
+		if !utf8.ValidString(database) {
+			database = "<invalid>"
+		}
+		counts[groupKey{database: database, source: clientSource(row.addr, opts)}]++
+	}
+
+	totals := make(map[string]float64)
+	for key, count := range counts {
+		totals[key.source] += count
+	}
+	if keep := topLabelValues(totals, opts.MaxSources); keep != nil {
+		folded := make(map[groupKey]float64, len(counts))
+		var foldedSeries float64
+		for key, count := range counts {
+			if !keep[key.source] {
+				key.source = otherLabelValue
+				foldedSeries++
+			}
+			folded[key] += count
+		}
+		counts = folded
+		ch <- prometheus.MustNewConstMetric(clientFoldedSeriesDesc, prometheus.GaugeValue, foldedSeries, "source")
+	} else if opts.MaxSources > 0 {
+		ch <- prometheus.MustNewConstMetric(clientFoldedSeriesDesc, prometheus.GaugeValue, 0, "source")
+	}
+
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(clientSourceConnectionsDesc, prometheus.GaugeValue, count, key.database, key.source)
+	}
+	return nil
+}
+
+// clientSource returns the network of a client address, collapsed to the
+// configured prefix length. Clients on the unix socket are "unix".
+func clientSource(addr string, opts ClientOptions) string {
+	if addr == "unix" {
+		return "unix"
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return "unknown"
+	}
+	ip = ip.Unmap()
+	bits := opts.SourcePrefixV6
+	if ip.Is4() {
+		bits = opts.SourcePrefixV4
+	}
+	prefix, err := ip.WithZone("").Prefix(bits)
+	if err != nil {
+		return "unknown"
+	}
+	return prefix.String()
+}
+
+// isOwnConnection returns whether a client is an admin console connection
+// of the exporter.
+func (o ClientOptions) isOwnConnection(database, applicationName string) bool {
+	return o.ownApplicationName != "" && database == "pgbouncer" && applicationName == o.ownApplicationName
+}
+
+// limitsLabels returns whether any cardinality limit is configured.
+func (o ClientOptions) limitsLabels() bool {
+	return o.MaxApplicationNames > 0 || o.MaxUsers > 0 || o.ApplicationNameAllow != nil || o.ApplicationNameDeny != nil
+}
+
+// applicationNameAllowed returns whether the application name passes the
+// allow and deny lists.
+func (o ClientOptions) applicationNameAllowed(name string) bool {
+	if o.ApplicationNameAllow != nil && !o.ApplicationNameAllow.MatchString(name) {
+		return false
+	}
+	if o.ApplicationNameDeny != nil && o.ApplicationNameDeny.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// topLabelValues returns the max label values with the highest totals, ties
+// broken by name, or nil if there are no more than max values. A max of zero
+// means no limit.
+func topLabelValues(totals map[string]float64, max int) map[string]bool {
+	if max <= 0 || len(totals) <= max {
+		return nil
+	}
+	values := slices.Collect(maps.Keys(totals))
+	slices.SortFunc(values, func(a, b string) int {
+		if c := cmp.Compare(totals[b], totals[a]); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	keep := make(map[string]bool, max)
+	for _, v := range values[:max] {
+		keep[v] = true
+	}
+	return keep
+}
+
+// constHistogram accumulates observations for a const histogram.
+type constHistogram struct {
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+// merge adds the observations of o to h.
+func (h *constHistogram) merge(o *constHistogram) {
+	h.count += o.count
+	h.sum += o.sum
+	for b, c := range o.buckets {
+		h.buckets[b] += c
+	}
+}
+
+// observe adds v to the histogram of key, creating it with the given upper
+// bounds if needed.
+func observe[K comparable](histograms map[K]*constHistogram, key K, bounds []float64, v float64) {
+	h, ok := histograms[key]
+	if !ok {
+		h = &constHistogram{buckets: make(map[float64]uint64, len(bounds))}
+		for _, b := range bounds {
+			h.buckets[b] = 0
+		}
+		histograms[key] = h
+	}
+	h.count++
+	h.sum += v
+	for _, b := range bounds {
+		if v <= b {
+			h.buckets[b]++
+		}
+	}
+}
+
+// Query SHOW SERVERS, aggregate by (database, user, addr, port, state), and emit
+// counts, along with the oldest connection and request age per pool.
+func queryShowServers(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB, filter LabelFilter, users *UserAnonymizer, logger *slog.Logger) error {
+	rows, err := db.QueryContext(ctx, "SHOW SERVERS;")
+	if err != nil {
+		return fmt.Errorf("error running SHOW SERVERS on database: %w", err)
+	}
+	defer rows.Close()
+
+	columnNames, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("error retrieving columns from SHOW SERVERS: %w", err)
+	}
+
+	colIdx := make(map[string]int, len(columnNames))
+	for i, name := range columnNames {
+		colIdx[name] = i
+	}
+
+	for _, required := range []string{"database", "user", "state", "addr", "port"} {
+		if _, ok := colIdx[required]; !ok {
+			return fmt.Errorf("SHOW SERVERS missing required column: %s", required)
+		}
+	}
+
+	type groupKey struct{ database, user, addr, port, state string }
+	type poolKey struct{ database, user string }
+	counts := make(map[groupKey]float64)
+	oldestConnect := make(map[poolKey]time.Time)
+	oldestRequest := make(map[poolKey]time.Time)
+
 	var (
-		dbCol    sql.RawBytes
-		userCol  sql.RawBytes
-		stateCol sql.RawBytes
-		appCol   sql.RawBytes
-		discard  sql.RawBytes
+		dbCol          sql.RawBytes
+		userCol        sql.RawBytes
+		stateCol       sql.RawBytes
+		addrCol        sql.RawBytes
+		portCol        sql.RawBytes
+		connectTimeCol sql.RawBytes
+		requestTimeCol sql.RawBytes
+		discard        sql.RawBytes
 	)
-	hasAppName := false
 	scanArgs := make([]any, len(columnNames))
 	for i, name := range columnNames {
 		switch name {
@@ -290,9 +1046,14 @@ func queryShowClients(ch chan<- prometheus.Metric, db *sql.DB, _ *slog.Logger) e
 			scanArgs[i] = &userCol
 		case "state":
 			scanArgs[i] = &stateCol
-		case "application_name":
-			hasAppName = true
-			scanArgs[i] = &appCol
+		case "addr":
+			scanArgs[i] = &addrCol
+		case "port":
+			scanArgs[i] = &portCol
+		case "connect_time":
+			scanArgs[i] = &connectTimeCol
+		case "request_time":
+			scanArgs[i] = &requestTimeCol
 		default:
 			scanArgs[i] = &discard
 		}
@@ -305,44 +1066,120 @@ func queryShowClients(ch chan<- prometheus.Metric, db *sql.DB, _ *slog.Logger) e
 		return s
 	}
 
+	// Keep the earliest timestamp seen for the pool.
+	trackOldest := func(oldest map[poolKey]time.Time, pool poolKey, raw sql.RawBytes) {
+		if len(raw) == 0 {
+			return
+		}
+		t, err := parseBouncerTime(string(raw))
+		if err != nil {
+			logger.Debug("SHOW SERVERS unparsable time", "value", string(raw), "err", err)
+			return
+		}
+		if current, ok := oldest[pool]; !ok || t.Before(current) {
+			oldest[pool] = t
+		}
+	}
+
 	for rows.Next() {
 		if err := rows.Scan(scanArgs...); err != nil {
-			return fmt.Errorf("error scanning SHOW CLIENTS row: %w", err)
+			return fmt.Errorf("error scanning SHOW SERVERS row: %w", err)
 		}
-		appName := ""
-		if hasAppName {
-			appName = string(appCol)
+		if !filter.allowsDatabase(string(dbCol)) || !filter.allowsUser(string(userCol)) {
+			continue
 		}
 		key := groupKey{
-			database:        sanitize(string(dbCol)),
-			user:            sanitize(string(userCol)),
-			applicationName: sanitize(appName),
-			state:           sanitize(string(stateCol)),
+			database: sanitize(string(dbCol)),
+			user:     sanitize(users.anonymize(string(userCol))),
+			addr:     sanitize(string(addrCol)),
+			port:     sanitize(string(portCol)),
+			state:    sanitize(string(stateCol)),
 		}
 		counts[key]++
+
+		pool := poolKey{database: key.database, user: key.user}
+		trackOldest(oldestConnect, pool, connectTimeCol)
+		trackOldest(oldestRequest, pool, requestTimeCol)
 	}
 	if err := rows.Err(); err != nil {
-		return fmt.Errorf("error iterating SHOW CLIENTS rows: %w", err)
+		return fmt.Errorf("error iterating SHOW SERVERS rows: %w", err)
 	}
 
 	for key, count := range counts {
 		ch <- prometheus.MustNewConstMetric(
-			clientConnectionsDesc,
+			serverConnectionsDesc,
 			prometheus.GaugeValue,
 			count,
-			key.database, key.user, key.applicationName, key.state,
+			key.database, key.user, key.addr, key.port, key.state,
 		)
 	}
+	// SHOW SERVERS has no timestamp of its own, so the clock of PgBouncer is
+	// taken from a SHOW CLIENTS earlier in the scrape.
+	clock := scrapeStateFrom(ctx).clock()
+	for pool, t := range oldestConnect {
+		ch <- prometheus.MustNewConstMetric(serverOldestConnectionAgeDesc, prometheus.GaugeValue, clock.since(t).Seconds(), pool.database, pool.user)
+	}
+	for pool, t := range oldestRequest {
+		ch <- prometheus.MustNewConstMetric(serverOldestRequestAgeDesc, prometheus.GaugeValue, clock.since(t).Seconds(), pool.database, pool.user)
+	}
 	return nil
 }
 
+// PgBouncer formats connect_time and request_time in its local time zone.
+var bouncerTimeLayouts = []string{
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+// Parse a timestamp as shown by SHOW CLIENTS and SHOW SERVERS.
+func parseBouncerTime(s string) (time.Time, error) {
+	var err error
+	for _, layout := range bouncerTimeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// bouncerClock measures the age of timestamps on the clock of PgBouncer. Go
+// only knows the offset of a zone abbreviation such as CEST if it is the local
+// zone of the exporter, and parses other abbreviations as UTC. The
+// request_time of the SHOW CLIENTS running is the current time of PgBouncer in
+// the same zone, so ages are measured from the latest request_time.
+type bouncerClock struct {
+	now  time.Time
+	read time.Time
+}
+
+// observe advances the clock to a request_time shown by PgBouncer.
+func (c *bouncerClock) observe(s string) {
+	if s == "" {
+		return
+	}
+	if t, err := parseBouncerTime(s); err == nil && t.After(c.now) {
+		c.now, c.read = t, time.Now()
+	}
+}
+
+// since returns the age of a timestamp shown by PgBouncer. Without a
+// request_time, the age is measured on the clock of the exporter.
+func (c bouncerClock) since(t time.Time) time.Duration {
+	if c.now.IsZero() {
+		return time.Since(t)
+	}
+	return c.now.Sub(t) + time.Since(c.read)
+}
+
 // Query within a namespace mapping and emit metrics. Returns fatal errors if
 // the scrape fails, and a slice of errors if they were non-fatal.
-func queryNamespaceMapping(ch chan<- prometheus.Metric, db *sql.DB, namespace string, mapping MetricMapNamespace, logger *slog.Logger) ([]error, error) {
+func queryNamespaceMapping(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB, namespace string, mapping MetricMapNamespace, version bouncerVersion, filter LabelFilter, users *UserAnonymizer, logger *slog.Logger) ([]error, error) {
 	query := fmt.Sprintf("SHOW %s;", namespace)
 
 	// Don't fail on a bad scrape of one metric
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		return []error{}, fmt.Errorf("error running query on database: %v, error: %w", namespace, err)
 	}
@@ -367,8 +1204,22 @@ func queryNamespaceMapping(ch chan<- prometheus.Metric, db *sql.DB, namespace st
 		scanArgs[i] = &columnData[i]
 	}
 
+	// Columns renamed or split by a release of PgBouncer are mapped under a
+	// single column name.
+	rewrites, rewriteTargets, metricColumns := rewriteColumns(mapping.rewrites, version, columnNames, columnIdx)
+
 	nonfatalErrors := []error{}
 
+	databaseColumn, userColumn := filterColumns(namespace)
+	filtered := func(column string, allows func(string) bool) bool {
+		idx, ok := columnIdx[column]
+		if !ok {
+			return false
+		}
+		value, _ := dbToString(columnData[idx])
+		return !allows(value)
+	}
+
 	for rows.Next() {
 		labelValues := make([]string, len(mapping.labels))
 		err = rows.Scan(scanArgs...)
@@ -376,6 +1227,10 @@ func queryNamespaceMapping(ch chan<- prometheus.Metric, db *sql.DB, namespace st
 			return []error{}, fmt.Errorf("error retrieving rows: %v, error: %w", namespace, err)
 		}
 
+		if filtered(databaseColumn, filter.allowsDatabase) || filtered(userColumn, filter.allowsUser) {
+			continue
+		}
+
 		for i, label := range mapping.labels {
 			for idx, columnName := range columnNames {
 				if columnName == label {
@@ -404,27 +1259,70 @@ func queryNamespaceMapping(ch chan<- prometheus.Metric, db *sql.DB, namespace st
 					}
 				}
 			}
+			if label == userColumn || userLabelColumns[label] {
+				labelValues[i] = users.anonymize(labelValues[i])
+			}
+		}
+
+		rowData := columnData
+		if len(rewrites) > 0 {
+			rowData = slices.Clone(columnData)
+			for i, rewrite := range rewrites {
+				value, ok := rewrite.combine(columnData, columnIdx)
+				if !ok {
+					nonfatalErrors = append(nonfatalErrors, fmt.Errorf("unexpected error combining namespace: %v, column: %v, sources: %v", namespace, rewrite.column, rewrite.sources))
+					rowData[rewriteTargets[i]] = nil
+					continue
+				}
+				rowData[rewriteTargets[i]] = value
+			}
 		}
 
 		// Loop over column names, and match to scan data. Unknown columns
 		// will be filled with an untyped metric number *if* they can be
 		// converted to float64s. NULLs are allowed and treated as NaN.
-		for idx, columnName := range columnNames {
+		for idx, columnName := range metricColumns {
 			if metricMapping, ok := mapping.columnMappings[columnName]; ok {
 				// Is this a metricy metric?
 				if metricMapping.discard {
 					continue
 				}
 
-				value, ok := metricMapping.conversion(columnData[idx])
+				value, ok := metricMapping.conversion(rowData[idx])
 				if !ok {
-					nonfatalErrors = append(nonfatalErrors, fmt.Errorf("unexpected error parsing namespace: %v, column: %v, index: %v", namespace, columnName, columnData[idx]))
+					nonfatalErrors = append(nonfatalErrors, fmt.Errorf("unexpected error parsing namespace: %v, column: %v, index: %v", namespace, columnName, rowData[idx]))
 					continue
 				}
 				// Generate the metric
 				ch <- prometheus.MustNewConstMetric(metricMapping.desc, metricMapping.vtype, value, labelValues...)
 			}
 		}
+
+		// Derive ratios from the raw column values of the row.
+		for _, ratio := range mapping.ratios {
+			numIdx, ok := columnIdx[ratio.numerator]
+			if !ok {
+				continue
+			}
+			denomIdx, ok := columnIdx[ratio.denominator]
+			if !ok {
+				continue
+			}
+			numerator, ok := dbToFloat64(columnData[numIdx], 1)
+			if !ok {
+				nonfatalErrors = append(nonfatalErrors, fmt.Errorf("unexpected error parsing namespace: %v, column: %v, index: %v", namespace, ratio.numerator, columnData[numIdx]))
+				continue
+			}
+			denominator, ok := dbToFloat64(columnData[denomIdx], 1)
+			if !ok {
+				nonfatalErrors = append(nonfatalErrors, fmt.Errorf("unexpected error parsing namespace: %v, column: %v, index: %v", namespace, ratio.denominator, columnData[denomIdx]))
+				continue
+			}
+			if math.IsNaN(numerator) || math.IsNaN(denominator) || denominator <= 0 {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(ratio.desc, prometheus.GaugeValue, numerator/denominator, labelValues...)
+		}
 	}
 	if err := rows.Err(); err != nil {
 		logger.Error("Failed scaning all rows", "err", err.Error())
@@ -433,23 +1331,6 @@ func queryNamespaceMapping(ch chan<- prometheus.Metric, db *sql.DB, namespace st
 	return nonfatalErrors, nil
 }
 
-func getDB(conn *pq.Connector) (*sql.DB, error) {
-	db := sql.OpenDB(conn)
-	if db == nil {
-		return nil, errors.New("error opening DB")
-	}
-	rows, err := db.Query("SHOW STATS")
-	if err != nil {
-		return nil, fmt.Errorf("error pinging pgbouncer: %w", err)
-	}
-	defer rows.Close()
-
-	db.SetMaxOpenConns(1)
-	db.SetMaxIdleConns(1)
-
-	return db, nil
-}
-
 // Convert database.sql types to float64s for Prometheus consumption. Null types are mapped to NaN. string and []byte
 // types are mapped as NaN and !ok
 func dbToFloat64(t interface{}, factor float64) (float64, bool) {
@@ -481,63 +1362,66 @@ func dbToFloat64(t interface{}, factor float64) (float64, bool) {
 	}
 }
 
-// Iterate through all the namespace mappings in the exporter and run their queries.
-func queryNamespaceMappings(ch chan<- prometheus.Metric, db *sql.DB, metricMap map[string]MetricMapNamespace, logger *slog.Logger) map[string]error {
-	// Return a map of namespace -> errors
-	namespaceErrors := make(map[string]error)
-
-	for namespace, mapping := range metricMap {
-		logger.Debug("Querying namespace", "namespace", namespace)
-		nonFatalErrors, err := queryNamespaceMapping(ch, db, namespace, mapping, logger)
-		// Serious error - a namespace disappeared
-		if err != nil {
-			namespaceErrors[namespace] = err
-			logger.Info("namespace disappeared", "err", err.Error())
-		}
-		// Non-serious errors - likely version or parsing problems.
-		if len(nonFatalErrors) > 0 {
-			for _, err := range nonFatalErrors {
-				logger.Info("error parsing", "err", err.Error())
-			}
-		}
+// Convert database.sql text types to strings. Null types are mapped to an empty
+// string.
+func dbToString(t interface{}) (string, bool) {
+	switch v := t.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	case nil:
+		return "", true
+	default:
+		return "", false
 	}
+}
 
-	return namespaceErrors
+// PgBouncer time units, as accepted in its configuration file.
+var durationUnits = map[string]float64{
+	"us":  1e-6,
+	"ms":  1e-3,
+	"s":   1,
+	"min": 60,
+	"h":   60 * 60,
+	"d":   24 * 60 * 60,
 }
 
-// Gather the pgbouncer version info.
-func queryVersion(ch chan<- prometheus.Metric, db *sql.DB) error {
-	rows, err := db.Query("SHOW VERSION;")
-	if err != nil {
-		return fmt.Errorf("error getting pgbouncer version: %w", err)
+// Convert a database.sql duration to seconds. Plain numbers are multiplied by
+// factor, strings may carry a PgBouncer time unit (e.g. "30s", "5 min") or be a
+// Go duration (e.g. "1h30m"). Null types are mapped to NaN.
+func dbToSeconds(t interface{}, factor float64) (float64, bool) {
+	var s string
+	switch v := t.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return dbToFloat64(t, factor)
 	}
-	defer rows.Close()
 
-	var columnNames []string
-	columnNames, err = rows.Columns()
-	if err != nil {
-		return fmt.Errorf("error retrieving column list for version: %w", err)
-	}
-	if len(columnNames) != 1 || columnNames[0] != "version" {
-		return errors.New("show version didn't return version column")
+	s = strings.TrimSpace(s)
+	if result, err := strconv.ParseFloat(s, 64); err == nil {
+		return result * factor, true
 	}
 
-	var bouncerVersion string
-
-	for rows.Next() {
-		err := rows.Scan(&bouncerVersion)
-		if err != nil {
-			return err
+	numEnd := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if numEnd > 0 {
+		if unit, ok := durationUnits[strings.TrimSpace(s[numEnd:])]; ok {
+			if result, err := strconv.ParseFloat(s[:numEnd], 64); err == nil {
+				return result * unit, true
+			}
 		}
-		ch <- prometheus.MustNewConstMetric(
-			bouncerVersionDesc,
-			prometheus.GaugeValue,
-			1.0,
-			bouncerVersion,
-		)
 	}
 
-	return nil
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return math.NaN(), false
+	}
+	return d.Seconds(), true
 }
 
 // Describe implements prometheus.Collector.
@@ -570,51 +1454,186 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect implements prometheus.Collector.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	e.logger.Debug("Starting scrape")
+	e.collect(context.Background(), ch)
+}
 
-	var up = 1.0
+// collect runs a scrape whose queries are cancelled once ctx is done. With
+// coalescing enabled, the scrape may be shared with other callers.
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	if e.coalesceWindow <= 0 {
+		e.scrape(ctx, ch)
+		return
+	}
 
-	defer func() {
-		ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, up)
-	}()
+	metrics, shared := e.sharedScrape(ctx)
+	for _, m := range metrics {
+		ch <- m
+	}
+	v := 0.0
+	if shared {
+		v = 1
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeSharedDesc, prometheus.GaugeValue, v)
+}
 
-	db, err := getDB(e.conn)
-	if err != nil {
-		e.logger.Warn("error setting up DB connection", "err", err.Error())
-		up = 0
-		return
+// WithCache serves the metrics of the last successful scrape for up to maxAge
+// while the admin console is unreachable. A zero maxAge disables the cache.
+func WithCache(maxAge time.Duration) ExporterOpt {
+	return func(e *Exporter) {
+		e.cacheMaxAge = maxAge
+	}
+}
+
+// sharedScrape returns the metrics of a scrape finished within the coalescing
+// window, or joins the scrape in flight, starting one if there is none. The
+// shared scrape is bounded by the context of the caller which started it.
+func (e *Exporter) sharedScrape(ctx context.Context) ([]prometheus.Metric, bool) {
+	e.sharedMtx.Lock()
+	if e.sharedMetrics != nil && time.Since(e.sharedAt) < e.coalesceWindow {
+		metrics := e.sharedMetrics
+		e.sharedMtx.Unlock()
+		return metrics, true
 	}
-	defer db.Close()
+	e.sharedMtx.Unlock()
+
+	// singleflight reports the result as shared to the caller running the
+	// scrape as well, once others joined it.
+	leader := false
+	v, _, _ := e.scrapeGroup.Do("scrape", func() (interface{}, error) {
+		leader = true
+		ch := make(chan prometheus.Metric)
+		var metrics []prometheus.Metric
+		done := make(chan struct{})
+		go func() {
+			for m := range ch {
+				metrics = append(metrics, m)
+			}
+			close(done)
+		}()
+		e.scrape(ctx, ch)
+		close(ch)
+		<-done
+
+		e.sharedMtx.Lock()
+		e.sharedMetrics = metrics
+		e.sharedAt = time.Now()
+		e.sharedMtx.Unlock()
+		return metrics, nil
+	})
+	return v.([]prometheus.Metric), !leader
+}
+
+// scrape queries PgBouncer, with all queries cancelled once ctx is done.
+func (e *Exporter) scrape(ctx context.Context, ch chan<- prometheus.Metric) {
+	e.logger.Debug("Starting scrape")
+	start := time.Now()
+
+	var up float64
+	if e.cacheMaxAge <= 0 {
+		up = e.runCollectors(ctx, ch, ch)
+	} else {
+		var metrics []prometheus.Metric
+		out := make(chan prometheus.Metric)
+		done := make(chan struct{})
+		go func() {
+			for m := range out {
+				metrics = append(metrics, m)
+			}
+			close(done)
+		}()
+		up = e.runCollectors(ctx, ch, out)
+		close(out)
+		<-done
+		e.sendCached(ch, metrics, up == 1)
+	}
+
+	reconnects, age, _ := e.conn.stats()
+	if up == 1 {
+		ch <- prometheus.MustNewConstMetric(connectionAgeDesc, prometheus.GaugeValue, age.Seconds())
+	}
+	ch <- prometheus.MustNewConstMetric(reconnectsDesc, prometheus.CounterValue, reconnects)
+	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds())
+}
 
-	err = queryVersion(ch, db)
+// runCollectors runs the collectors, sending their metrics to out and the
+// outcome of each collector to ch. It returns whether the admin console is
+// reachable.
+func (e *Exporter) runCollectors(ctx context.Context, ch, out chan<- prometheus.Metric) float64 {
+	// Take the connection out of the pool, dialing it if there is none, to
+	// skip the collectors while PgBouncer is unreachable.
+	conn, err := e.db.Conn(ctx)
 	if err != nil {
-		e.logger.Warn("error getting version", "err", err.Error())
-		up = 0
+		e.logger.Warn("error setting up DB connection", "err", err.Error())
+		return 0
 	}
+	conn.Close()
 
-	if err = queryShowLists(ch, db, e.logger); err != nil {
-		e.logger.Warn("error getting SHOW LISTS", "err", err.Error())
-		up = 0
+	// Column rewrites, the pool sampler and version dependent collectors need
+	// the version of PgBouncer.
+	if _, err := e.detectVersion(ctx); err != nil {
+		e.logger.Debug("error detecting pgbouncer version", "err", err.Error())
 	}
 
-	if err = queryShowConfig(ch, db, e.logger); err != nil {
-		e.logger.Warn("error getting SHOW CONFIG", "err", err.Error())
-		up = 0
+	ctx = withScrapeState(ctx)
+
+	// A failing collector only affects its own success metric, up reports
+	// whether the admin console is reachable.
+	for _, name := range sortedCollectorNames(e.collectors) {
+		begin := time.Now()
+		err := e.collectors[name].Update(ctx, out, e.db)
+		duration := time.Since(begin)
+
+		success, timeout := 1.0, 0.0
+		switch {
+		case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+			e.logger.Warn("collector timed out", "collector", name, "duration_seconds", duration.Seconds(), "err", err.Error())
+			success, timeout = 0, 1
+		case err != nil:
+			e.logger.Warn("error running collector", "collector", name, "duration_seconds", duration.Seconds(), "err", err.Error())
+			success = 0
+		default:
+			e.logger.Debug("collector succeeded", "collector", name, "duration_seconds", duration.Seconds())
+		}
+		ch <- prometheus.MustNewConstMetric(collectorDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(collectorSuccessDesc, prometheus.GaugeValue, success, name)
+		ch <- prometheus.MustNewConstMetric(collectorTimeoutDesc, prometheus.GaugeValue, timeout, name)
 	}
 
-	if err = queryShowClients(ch, db, e.logger); err != nil {
-		e.logger.Warn("error getting SHOW CLIENTS", "err", err.Error())
-		up = 0
+	// A broken connection is replaced by the first query after it failed,
+	// so the last connection attempt tells whether PgBouncer went away
+	// during the scrape.
+	if _, _, err := e.conn.stats(); err != nil {
+		e.logger.Warn("error connecting to pgbouncer", "err", err.Error())
+		return 0
 	}
+	return 1
+}
 
-	errMap := queryNamespaceMappings(ch, db, e.metricMap, e.logger)
-	if len(errMap) > 0 {
-		e.logger.Warn("error querying namespace mappings", "err", errMap)
-		up = 0
+// sendCached sends the metrics of a successful scrape and remembers them. For
+// a failed scrape it sends the metrics of the last successful scrape instead,
+// unless they are older than the maximum cache age.
+func (e *Exporter) sendCached(ch chan<- prometheus.Metric, metrics []prometheus.Metric, success bool) {
+	e.cacheMtx.Lock()
+	now := time.Now()
+	cached := 0.0
+	if success {
+		e.cachedMetrics = metrics
+		e.cachedAt = now
+	} else if e.cachedMetrics != nil && now.Sub(e.cachedAt) <= e.cacheMaxAge {
+		e.logger.Info("serving metrics of the last successful scrape", "age_seconds", now.Sub(e.cachedAt).Seconds())
+		metrics = e.cachedMetrics
+		cached = 1
 	}
+	lastSuccess := e.cachedAt
+	e.cacheMtx.Unlock()
 
-	if len(errMap) == len(e.metricMap) {
-		up = 0
+	for _, m := range metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeCachedDesc, prometheus.GaugeValue, cached)
+	if !lastSuccess.IsZero() {
+		ch <- prometheus.MustNewConstMetric(lastSuccessfulScrapeDesc, prometheus.GaugeValue, float64(lastSuccess.UnixNano())/1e9)
 	}
 }
 
@@ -639,6 +1658,12 @@ func makeDescMap(metricMaps map[string]map[string]ColumnMapping, namespace strin
 
 			// Determine how to convert the column based on its usage.
 			switch columnMapping.usage {
+			case DISCARD:
+				// Discarded columns are skipped while mapping and have no
+				// descriptor, so they never reach Describe.
+				thisMap[columnName] = MetricMap{
+					discard: true,
+				}
 			case COUNTER:
 				thisMap[columnName] = MetricMap{
 					vtype: prometheus.CounterValue,
@@ -655,10 +1680,58 @@ func makeDescMap(metricMaps map[string]map[string]ColumnMapping, namespace strin
 						return dbToFloat64(in, factor)
 					},
 				}
+			case MAPPEDMETRIC:
+				valueMapping := columnMapping.mapping
+				thisMap[columnName] = MetricMap{
+					vtype: prometheus.GaugeValue,
+					desc:  prometheus.NewDesc(fmt.Sprintf("%s_%s_%s", namespace, metricNamespace, columnMapping.metric), columnMapping.description, labels, nil),
+					conversion: func(in interface{}) (float64, bool) {
+						text, ok := dbToString(in)
+						if !ok {
+							return math.NaN(), false
+						}
+						value, ok := valueMapping[text]
+						if !ok {
+							return math.NaN(), false
+						}
+						return value, true
+					},
+				}
+			case DURATION:
+				thisMap[columnName] = MetricMap{
+					vtype: prometheus.GaugeValue,
+					desc:  prometheus.NewDesc(fmt.Sprintf("%s_%s_%s", namespace, metricNamespace, columnMapping.metric), columnMapping.description, labels, nil),
+					conversion: func(in interface{}) (float64, bool) {
+						return dbToSeconds(in, factor)
+					},
+				}
 			}
 		}
 
-		metricMap[metricNamespace] = MetricMapNamespace{thisMap, labels}
+		var ratios []RatioMap
+		for _, ratio := range ratioMaps[metricNamespace] {
+			ratios = append(ratios, RatioMap{
+				numerator:   ratio.numerator,
+				denominator: ratio.denominator,
+				desc:        prometheus.NewDesc(fmt.Sprintf("%s_%s_%s", namespace, metricNamespace, ratio.metric), ratio.description, labels, nil),
+			})
+		}
+
+		// Rewrites need the mapping of their column, which a mappings file
+		// may have removed from a built-in namespace.
+		var rewrites []ColumnRewrite
+		for _, rewrite := range columnRewrites[metricNamespace] {
+			if _, ok := thisMap[rewrite.column]; ok {
+				rewrites = append(rewrites, rewrite)
+			}
+		}
+
+		metricMap[metricNamespace] = MetricMapNamespace{
+			columnMappings: thisMap,
+			labels:         labels,
+			ratios:         ratios,
+			rewrites:       rewrites,
+		}
 	}
 
 	return metricMap
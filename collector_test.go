@@ -12,11 +12,17 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"log/slog"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/smartystreets/goconvey/convey"
@@ -68,7 +74,7 @@ func TestQueryShowList(t *testing.T) {
 	ch := make(chan prometheus.Metric)
 	go func() {
 		defer close(ch)
-		if err := queryShowLists(ch, db, logger); err != nil {
+		if err := queryShowLists(context.Background(), ch, db, 0, logger); err != nil {
 			t.Errorf("Error running queryShowList: %s", err)
 		}
 	}()
@@ -110,7 +116,7 @@ func TestQueryShowConfig(t *testing.T) {
 	ch := make(chan prometheus.Metric)
 	go func() {
 		defer close(ch)
-		if err := queryShowConfig(ch, db, logger); err != nil {
+		if err := queryShowConfig(context.Background(), ch, db, logger); err != nil {
 			t.Errorf("Error running queryShowConfig: %s", err)
 		}
 	}()
@@ -153,7 +159,7 @@ func TestQueryShowClients(t *testing.T) {
 	ch := make(chan prometheus.Metric)
 	go func() {
 		defer close(ch)
-		if err := queryShowClients(ch, db, logger); err != nil {
+		if _, err := queryShowClients(context.Background(), ch, db, ClientOptions{}, logger); err != nil {
 			t.Errorf("Error running queryShowClients: %s", err)
 		}
 	}()
@@ -201,7 +207,7 @@ func TestQueryShowClientsNoApplicationName(t *testing.T) {
 	ch := make(chan prometheus.Metric)
 	go func() {
 		defer close(ch)
-		if err := queryShowClients(ch, db, logger); err != nil {
+		if _, err := queryShowClients(context.Background(), ch, db, ClientOptions{}, logger); err != nil {
 			t.Errorf("Error running queryShowClients without application_name: %s", err)
 		}
 	}()
@@ -296,7 +302,22 @@ func TestQueryShowPools(t *testing.T) {
 	testQueryNamespaceMapping(t, "pools", rows, expected)
 }
 
+func TestQueryShowPoolsPoolMode(t *testing.T) {
+	rows := sqlmock.NewRows([]string{"database", "user", "pool_mode"}).
+		AddRow("pg0", "postgres", "transaction")
+
+	expected := []MetricResult{
+		{labels: labelMap{"database": "pg0", "user": "postgres"}, metricType: dto.MetricType_GAUGE, value: 1},
+	}
+
+	testQueryNamespaceMapping(t, "pools", rows, expected)
+}
+
 func testQueryNamespaceMapping(t *testing.T, namespaceMapping string, rows *sqlmock.Rows, expected []MetricResult) {
+	testQueryNamespaceMappingVersion(t, namespaceMapping, bouncerVersion{}, rows, expected)
+}
+
+func testQueryNamespaceMappingVersion(t *testing.T, namespaceMapping string, version bouncerVersion, rows *sqlmock.Rows, expected []MetricResult) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("Error opening a stub db connection: %s", err)
@@ -312,11 +333,224 @@ func testQueryNamespaceMapping(t *testing.T, namespaceMapping string, rows *sqlm
 	ch := make(chan prometheus.Metric)
 	go func() {
 		defer close(ch)
-		if _, err := queryNamespaceMapping(ch, db, namespaceMapping, metricMap[namespaceMapping], logger); err != nil {
+		if _, err := queryNamespaceMapping(context.Background(), ch, db, namespaceMapping, metricMap[namespaceMapping], version, LabelFilter{}, nil, logger); err != nil {
+			t.Errorf("Error running queryNamespaceMapping: %s", err)
+		}
+	}()
+
+	convey.Convey("Metrics comparison", t, func() {
+		for _, expect := range expected {
+			m := readMetric(<-ch)
+			convey.So(m, convey.ShouldResemble, expect)
+		}
+	})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled exceptions: %s", err)
+	}
+}
+
+func TestQueryMappedMetricAndDuration(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"name", "pool_mode", "server_lifetime", "query_wait_timeout", "wait_us"}).
+		AddRow("pg0", "transaction", "1h", "2 min", 1500000).
+		AddRow("pg1", "session", "3600", "1m30s", 250)
+	mock.ExpectQuery("SHOW modes;").WillReturnRows(rows)
+
+	maps := map[string]map[string]ColumnMapping{
+		"modes": {
+			"name":               {LABEL, "N/A", 1, "N/A", nil},
+			"pool_mode":          {MAPPEDMETRIC, "pool_mode", 1, "Pool mode", map[string]float64{"session": 0, "transaction": 1, "statement": 2}},
+			"server_lifetime":    {DURATION, "server_lifetime_seconds", 1, "Server lifetime", nil},
+			"query_wait_timeout": {DURATION, "query_wait_timeout_seconds", 1, "Query wait timeout", nil},
+			"wait_us":            {DURATION, "wait_seconds", 1e-6, "Wait time", nil},
+		},
+	}
+	logger := slog.Default()
+	metricMap := makeDescMap(maps, namespace, logger)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		nonfatal, err := queryNamespaceMapping(context.Background(), ch, db, "modes", metricMap["modes"], bouncerVersion{}, LabelFilter{}, nil, logger)
+		if err != nil {
 			t.Errorf("Error running queryNamespaceMapping: %s", err)
 		}
+		if len(nonfatal) > 0 {
+			t.Errorf("Unexpected non-fatal errors: %v", nonfatal)
+		}
+	}()
+
+	found := map[string]float64{}
+	for m := range ch {
+		desc := m.Desc().String()
+		r := readMetric(m)
+		for _, name := range []string{"pool_mode", "server_lifetime_seconds", "query_wait_timeout_seconds", "wait_seconds"} {
+			if strings.Contains(desc, `"pgbouncer_modes_`+name+`"`) {
+				found[r.labels["name"]+"/"+name] = r.value
+			}
+		}
+	}
+
+	convey.Convey("Mapped metrics and durations are converted", t, func() {
+		convey.So(found["pg0/pool_mode"], convey.ShouldEqual, 1)
+		convey.So(found["pg1/pool_mode"], convey.ShouldEqual, 0)
+		convey.So(found["pg0/server_lifetime_seconds"], convey.ShouldEqual, 3600)
+		convey.So(found["pg1/server_lifetime_seconds"], convey.ShouldEqual, 3600)
+		convey.So(found["pg0/query_wait_timeout_seconds"], convey.ShouldEqual, 120)
+		convey.So(found["pg1/query_wait_timeout_seconds"], convey.ShouldEqual, 90)
+		convey.So(found["pg0/wait_seconds"], convey.ShouldAlmostEqual, 1.5)
+		convey.So(found["pg1/wait_seconds"], convey.ShouldAlmostEqual, 0.00025)
+	})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled exceptions: %s", err)
+	}
+}
+
+func TestQueryDiscardColumns(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"name", "host", "port", "database", "pool_size", "paused"}).
+		AddRow("pg0_db", "10.10.10.1", "5432", "pg0", 20, 0)
+	mock.ExpectQuery("SHOW databases;").WillReturnRows(rows)
+
+	maps := mergeColumnMappings(metricMaps, map[string]map[string]ColumnMapping{
+		"databases": {
+			"host":   {usage: DISCARD},
+			"paused": {usage: DISCARD},
+		},
+	})
+	logger := slog.Default()
+	metricMap := makeDescMap(maps, namespace, logger)
+
+	convey.Convey("Discarded columns are flagged and not used as labels", t, func() {
+		convey.So(metricMap["databases"].columnMappings["paused"].discard, convey.ShouldBeTrue)
+		convey.So(metricMap["databases"].columnMappings["paused"].desc, convey.ShouldBeNil)
+		convey.So(metricMap["databases"].labels, convey.ShouldNotContain, "host")
+	})
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if _, err := queryNamespaceMapping(context.Background(), ch, db, "databases", metricMap["databases"], bouncerVersion{}, LabelFilter{}, nil, logger); err != nil {
+			t.Errorf("Error running queryNamespaceMapping: %s", err)
+		}
+	}()
+
+	results := []MetricResult{}
+	for m := range ch {
+		results = append(results, readMetric(m))
+	}
+
+	convey.Convey("Discarded columns are not exported", t, func() {
+		convey.So(results, convey.ShouldResemble, []MetricResult{
+			{labels: labelMap{"name": "pg0_db", "port": "5432", "database": "pg0", "force_user": "", "pool_mode": ""}, metricType: dto.MetricType_GAUGE, value: 20},
+		})
+	})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled exceptions: %s", err)
+	}
+}
+
+func TestQueryShowServers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	connectTime := time.Now().Add(-time.Hour).UTC().Format("2006-01-02 15:04:05 MST")
+	requestTime := time.Now().Add(-time.Minute).UTC().Format("2006-01-02 15:04:05 MST")
+	recent := time.Now().UTC().Format("2006-01-02 15:04:05 MST")
+
+	rows := sqlmock.NewRows([]string{"type", "user", "database", "state", "addr",
+		"port", "local_addr", "local_port", "connect_time", "request_time",
+		"wait", "wait_us", "close_needed", "ptr", "link", "remote_pid", "tls", "application_name"}).
+		AddRow("S", "alice", "mydb", "active", "10.0.1.1", 5432, "10.0.0.2", 40000,
+			connectTime, recent, 0, 0, 0, "0x0", "0x10", 100, "", "").
+		AddRow("S", "alice", "mydb", "active", "10.0.1.1", 5432, "10.0.0.2", 40001,
+			recent, requestTime, 0, 0, 0, "0x1", "0x11", 101, "", "").
+		AddRow("S", "alice", "mydb", "idle", "10.0.1.2", 5432, "10.0.0.2", 40002,
+			recent, recent, 0, 0, 0, "0x2", "", 102, "", "")
+
+	mock.ExpectQuery("SHOW SERVERS;").WillReturnRows(rows)
+	logger := slog.Default()
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if err := queryShowServers(context.Background(), ch, db, LabelFilter{}, nil, logger); err != nil {
+			t.Errorf("Error running queryShowServers: %s", err)
+		}
+	}()
+
+	counts := map[string]float64{}
+	ages := map[string]float64{}
+	for m := range ch {
+		r := readMetric(m)
+		switch m.Desc() {
+		case serverConnectionsDesc:
+			counts[r.labels["addr"]+":"+r.labels["port"]+"/"+r.labels["state"]] = r.value
+		case serverOldestConnectionAgeDesc:
+			ages["connection"] = r.value
+		case serverOldestRequestAgeDesc:
+			ages["request"] = r.value
+		}
+	}
+
+	convey.Convey("Server connections aggregated by backend and state", t, func() {
+		convey.So(len(counts), convey.ShouldEqual, 2)
+		convey.So(counts["10.0.1.1:5432/active"], convey.ShouldEqual, 2)
+		convey.So(counts["10.0.1.2:5432/idle"], convey.ShouldEqual, 1)
+		convey.So(ages["connection"], convey.ShouldAlmostEqual, 3600, 5)
+		convey.So(ages["request"], convey.ShouldAlmostEqual, 60, 5)
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestQueryShowMem(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"name", "size", "used", "free", "memtotal"}).
+		AddRow("user_cache", 184, 4, 85, 16376).
+		AddRow("future_cache", 64, 1, 1, 128)
+
+	mock.ExpectQuery("SHOW MEM;").WillReturnRows(rows)
+	logger := slog.Default()
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if err := queryShowMem(context.Background(), ch, db, logger); err != nil {
+			t.Errorf("Error running queryShowMem: %s", err)
+		}
 	}()
 
+	expected := []MetricResult{
+		{labels: labelMap{"cache": "user_cache"}, metricType: dto.MetricType_GAUGE, value: 184},
+		{labels: labelMap{"cache": "user_cache"}, metricType: dto.MetricType_GAUGE, value: 4},
+		{labels: labelMap{"cache": "user_cache"}, metricType: dto.MetricType_GAUGE, value: 85},
+		{labels: labelMap{"cache": "user_cache"}, metricType: dto.MetricType_GAUGE, value: 16376},
+		{labels: labelMap{"cache": "future_cache"}, metricType: dto.MetricType_GAUGE, value: 64},
+		{labels: labelMap{"cache": "future_cache"}, metricType: dto.MetricType_GAUGE, value: 1},
+		{labels: labelMap{"cache": "future_cache"}, metricType: dto.MetricType_GAUGE, value: 1},
+		{labels: labelMap{"cache": "future_cache"}, metricType: dto.MetricType_GAUGE, value: 128},
+	}
 	convey.Convey("Metrics comparison", t, func() {
 		for _, expect := range expected {
 			m := readMetric(<-ch)
@@ -327,3 +561,480 @@ func testQueryNamespaceMapping(t *testing.T, namespaceMapping string, rows *sqlm
 		t.Errorf("there were unfulfilled exceptions: %s", err)
 	}
 }
+
+func TestQueryShowStatsAverages(t *testing.T) {
+	rows := sqlmock.NewRows([]string{"database", "xact_count", "query_count", "bytes_received", "bytes_sent",
+		"xact_time", "query_time", "wait_time"}).
+		AddRow("pg0", 10, 40, 220, 460, 6, 8, 9)
+
+	expected := []MetricResult{
+		{labels: labelMap{"database": "pg0"}, metricType: dto.MetricType_GAUGE, value: 10},   // xact_count
+		{labels: labelMap{"database": "pg0"}, metricType: dto.MetricType_GAUGE, value: 40},   // query_count
+		{labels: labelMap{"database": "pg0"}, metricType: dto.MetricType_GAUGE, value: 220},  // bytes_received
+		{labels: labelMap{"database": "pg0"}, metricType: dto.MetricType_GAUGE, value: 460},  // bytes_sent
+		{labels: labelMap{"database": "pg0"}, metricType: dto.MetricType_GAUGE, value: 6e-6}, // xact_time
+		{labels: labelMap{"database": "pg0"}, metricType: dto.MetricType_GAUGE, value: 8e-6}, // query_time
+		{labels: labelMap{"database": "pg0"}, metricType: dto.MetricType_GAUGE, value: 9e-6}, // wait_time
+	}
+
+	testQueryNamespaceMapping(t, "stats_averages", rows, expected)
+}
+
+func TestQueryShowUsers(t *testing.T) {
+	rows := sqlmock.NewRows([]string{"name", "pool_size", "reserve_pool_size", "pool_mode",
+		"max_user_connections", "current_connections", "max_user_client_connections", "current_client_connections"}).
+		AddRow("alice", 20, 5, "transaction", 50, 10, 0, 3)
+
+	labels := labelMap{"name": "alice", "pool_mode": "transaction"}
+	expected := []MetricResult{
+		{labels: labels, metricType: dto.MetricType_GAUGE, value: 20},  // pool_size
+		{labels: labels, metricType: dto.MetricType_GAUGE, value: 5},   // reserve_pool_size
+		{labels: labels, metricType: dto.MetricType_GAUGE, value: 50},  // max_user_connections
+		{labels: labels, metricType: dto.MetricType_GAUGE, value: 10},  // current_connections
+		{labels: labels, metricType: dto.MetricType_GAUGE, value: 0},   // max_user_client_connections
+		{labels: labels, metricType: dto.MetricType_GAUGE, value: 3},   // current_client_connections
+		{labels: labels, metricType: dto.MetricType_GAUGE, value: 0.2}, // connection_utilization_ratio, the client ratio is skipped without a limit
+	}
+
+	testQueryNamespaceMapping(t, "users", rows, expected)
+}
+
+func TestQueryShowState(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"key", "value"}).
+		AddRow("active", "no").
+		AddRow("paused", "yes").
+		AddRow("suspended", "no")
+
+	mock.ExpectQuery("SHOW STATE;").WillReturnRows(rows)
+	logger := slog.Default()
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if err := queryShowState(context.Background(), ch, db, logger); err != nil {
+			t.Errorf("Error running queryShowState: %s", err)
+		}
+	}()
+
+	expected := []MetricResult{
+		{labels: labelMap{}, metricType: dto.MetricType_GAUGE, value: 0},
+		{labels: labelMap{}, metricType: dto.MetricType_GAUGE, value: 1},
+		{labels: labelMap{}, metricType: dto.MetricType_GAUGE, value: 0},
+	}
+	convey.Convey("Metrics comparison", t, func() {
+		for _, expect := range expected {
+			m := readMetric(<-ch)
+			convey.So(m, convey.ShouldResemble, expect)
+		}
+		_, more := <-ch
+		convey.So(more, convey.ShouldBeFalse)
+	})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled exceptions: %s", err)
+	}
+}
+
+func TestStateCollector(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	e := &Exporter{logger: slog.Default()}
+	state := factories["state"](e)
+	update := func(ctx context.Context) error {
+		ch := make(chan prometheus.Metric, 10)
+		defer close(ch)
+		return state.Update(ctx, ch, db)
+	}
+	badShowArg := &pq.Error{Severity: "ERROR", Code: "08P01", Message: "bad SHOW arg"}
+
+	convey.Convey("A missing SHOW STATE is ignored while the version is unknown", t, func() {
+		mock.ExpectQuery("SHOW STATE;").WillReturnError(badShowArg)
+		convey.So(update(context.Background()), convey.ShouldBeNil)
+	})
+
+	convey.Convey("Other errors fail the collector", t, func() {
+		mock.ExpectQuery("SHOW STATE;").WillReturnError(errors.New("connection reset"))
+		convey.So(update(context.Background()), convey.ShouldNotBeNil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		convey.So(update(ctx), convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("A missing SHOW STATE fails the collector on a known version", t, func() {
+		e.version = bouncerVersion{1, 24, 1}
+		mock.ExpectQuery("SHOW STATE;").WillReturnError(badShowArg)
+		convey.So(update(context.Background()), convey.ShouldNotBeNil)
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestQueryShowClientsHistograms(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	connected := time.Now().Add(-90 * time.Second).Format("2006-01-02 15:04:05")
+	rows := sqlmock.NewRows([]string{"type", "user", "database", "state", "connect_time", "wait", "wait_us"}).
+		AddRow("C", "alice", "mydb", "waiting", connected, 2, 500000).
+		AddRow("C", "alice", "mydb", "active", connected, 0, 0)
+
+	mock.ExpectQuery("SHOW CLIENTS;").WillReturnRows(rows)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if _, err := queryShowClients(context.Background(), ch, db, ClientOptions{Histograms: true}, slog.Default()); err != nil {
+			t.Errorf("Error running queryShowClients: %s", err)
+		}
+	}()
+
+	histograms := map[*prometheus.Desc]*dto.Histogram{}
+	for m := range ch {
+		pb := &dto.Metric{}
+		m.Write(pb)
+		if pb.Histogram != nil {
+			histograms[m.Desc()] = pb.Histogram
+		}
+	}
+
+	convey.Convey("Client wait times are observed per pool", t, func() {
+		h := histograms[clientWaitDesc]
+		convey.So(h, convey.ShouldNotBeNil)
+		convey.So(h.GetSampleCount(), convey.ShouldEqual, 2)
+		convey.So(h.GetSampleSum(), convey.ShouldEqual, 2.5)
+		for _, b := range h.GetBucket() {
+			switch b.GetUpperBound() {
+			case 1:
+				convey.So(b.GetCumulativeCount(), convey.ShouldEqual, 1)
+			case 5:
+				convey.So(b.GetCumulativeCount(), convey.ShouldEqual, 2)
+			}
+		}
+	})
+
+	convey.Convey("Client connection ages are observed per pool", t, func() {
+		h := histograms[clientAgeDesc]
+		convey.So(h, convey.ShouldNotBeNil)
+		convey.So(h.GetSampleCount(), convey.ShouldEqual, 2)
+		convey.So(h.GetSampleSum(), convey.ShouldBeBetween, 179, 185)
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBouncerTimeZone(t *testing.T) {
+	// CEST is not the local zone, so Go parses it with a zero offset.
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW CLIENTS;").WillReturnRows(
+		sqlmock.NewRows([]string{"type", "user", "database", "state", "application_name", "connect_time", "request_time", "wait", "wait_us"}).
+			AddRow("C", "alice", "mydb", "active", "psql", "2026-07-01 11:58:30 CEST", "2026-07-01 11:59:00 CEST", 0, 0).
+			AddRow("C", "pgbouncer", "pgbouncer", "active", exporterApplicationName, "2026-07-01 10:00:00 CEST", "2026-07-01 12:00:00 CEST", 0, 0))
+	mock.ExpectQuery("SHOW SERVERS;").WillReturnRows(
+		sqlmock.NewRows([]string{"user", "database", "state", "addr", "port", "connect_time", "request_time"}).
+			AddRow("alice", "mydb", "active", "10.0.1.1", 5432, "2026-07-01 11:00:00 CEST", "2026-07-01 11:59:00 CEST"))
+
+	ctx := withScrapeState(context.Background())
+	ch := make(chan prometheus.Metric, 100)
+	opts := ClientOptions{Histograms: true, ownApplicationName: exporterApplicationName}
+	if _, err := queryShowClients(ctx, ch, db, opts, slog.Default()); err != nil {
+		t.Errorf("Error running queryShowClients: %s", err)
+	}
+	if err := queryShowServers(ctx, ch, db, LabelFilter{}, nil, slog.Default()); err != nil {
+		t.Errorf("Error running queryShowServers: %s", err)
+	}
+	close(ch)
+
+	ages := map[*prometheus.Desc]float64{}
+	for m := range ch {
+		pb := &dto.Metric{}
+		m.Write(pb)
+		if pb.Histogram != nil {
+			ages[m.Desc()] = pb.Histogram.GetSampleSum()
+		} else {
+			ages[m.Desc()] = pb.GetGauge().GetValue()
+		}
+	}
+
+	convey.Convey("Ages are measured on the clock of PgBouncer", t, func() {
+		convey.So(ages[clientAgeDesc], convey.ShouldAlmostEqual, 90, 5)
+		convey.So(ages[serverOldestConnectionAgeDesc], convey.ShouldAlmostEqual, 3600, 5)
+		convey.So(ages[serverOldestRequestAgeDesc], convey.ShouldAlmostEqual, 60, 5)
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestQueryShowClientsCardinality(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"type", "user", "database", "state", "application_name"})
+	for _, app := range []string{"web", "web", "web", "worker", "worker", "worker", "req-abc", "req-def", "debug-1", "debug-2", "debug-3", "debug-4"} {
+		rows.AddRow("C", "alice", "mydb", "active", app)
+	}
+	rows.AddRow("C", "alice", "mydb", "idle", "req-abc")
+	mock.ExpectQuery("SHOW CLIENTS;").WillReturnRows(rows)
+
+	opts := ClientOptions{
+		MaxApplicationNames: 2,
+		ApplicationNameDeny: regexp.MustCompile("^(?:debug-.*)$"),
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if _, err := queryShowClients(context.Background(), ch, db, opts, slog.Default()); err != nil {
+			t.Errorf("Error running queryShowClients: %s", err)
+		}
+	}()
+
+	connections := map[string]float64{}
+	folded := map[string]float64{}
+	for m := range ch {
+		r := readMetric(m)
+		switch m.Desc() {
+		case clientConnectionsDesc:
+			connections[r.labels["application_name"]+"/"+r.labels["state"]] = r.value
+		case clientFoldedSeriesDesc:
+			folded[r.labels["label"]] = r.value
+		}
+	}
+
+	convey.Convey("Application names over the limit or denied are folded", t, func() {
+		convey.So(connections, convey.ShouldResemble, map[string]float64{"web/active": 3, "worker/active": 3, "other/active": 6, "other/idle": 1})
+	})
+
+	convey.Convey("Denied application names take no place in the limit", t, func() {
+		convey.So(connections, convey.ShouldContainKey, "worker/active")
+	})
+
+	convey.Convey("Folded series are counted", t, func() {
+		convey.So(folded, convey.ShouldResemble, map[string]float64{"application_name": 7, "user": 0})
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestQueryShowClientSources(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"type", "user", "database", "state", "addr", "port"})
+	for _, addr := range []string{"10.0.1.5", "10.0.1.200", "10.0.2.7", "::ffff:10.0.1.9", "2001:db8::1", "2001:db8::2", "unix", "192.168.0.1"} {
+		rows.AddRow("C", "alice", "mydb", "active", addr, 5432)
+	}
+	mock.ExpectQuery("SHOW CLIENTS;").WillReturnRows(rows)
+
+	opts := ClientOptions{SourcePrefixV4: 24, SourcePrefixV6: 64, MaxSources: 4}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if err := queryShowClientSources(context.Background(), ch, db, opts, slog.Default()); err != nil {
+			t.Errorf("Error running queryShowClientSources: %s", err)
+		}
+	}()
+
+	sources := map[string]float64{}
+	folded := map[string]float64{}
+	for m := range ch {
+		r := readMetric(m)
+		switch m.Desc() {
+		case clientSourceConnectionsDesc:
+			sources[r.labels["source"]] = r.value
+		case clientFoldedSeriesDesc:
+			folded[r.labels["label"]] = r.value
+		}
+	}
+
+	convey.Convey("Client addresses are collapsed to networks", t, func() {
+		convey.So(sources, convey.ShouldResemble, map[string]float64{
+			"10.0.1.0/24":    3,
+			"2001:db8::/64":  2,
+			"10.0.2.0/24":    1,
+			"192.168.0.0/24": 1,
+			"other":          1,
+		})
+		convey.So(folded, convey.ShouldResemble, map[string]float64{"source": 1})
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestExporterOwnConnection(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW CLIENTS;").WillReturnRows(
+		sqlmock.NewRows([]string{"type", "user", "database", "state", "application_name"}).
+			AddRow("C", "stats", "pgbouncer", "active", exporterApplicationName).
+			AddRow("C", "stats", "pgbouncer", "active", exporterApplicationName).
+			AddRow("C", "stats", "pgbouncer", "active", "psql").
+			AddRow("C", "alice", "mydb", "active", exporterApplicationName))
+	mock.ExpectQuery("SHOW LISTS;").WillReturnRows(
+		sqlmock.NewRows([]string{"list", "items"}).
+			AddRow("used_clients", 4))
+
+	opts := ClientOptions{ownApplicationName: exporterApplicationName}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		// Another exporter, such as the second of an HA pair, is connected
+		// as well.
+		own, err := queryShowClients(context.Background(), ch, db, opts, slog.Default())
+		if err != nil {
+			t.Errorf("Error running queryShowClients: %s", err)
+		}
+		if own != 2 {
+			t.Errorf("Expected 2 own connections, got %v", own)
+		}
+		if err := queryShowLists(context.Background(), ch, db, own, slog.Default()); err != nil {
+			t.Errorf("Error running queryShowLists: %s", err)
+		}
+	}()
+
+	connections := map[string]float64{}
+	var usedClients float64
+	for m := range ch {
+		r := readMetric(m)
+		switch m.Desc() {
+		case clientConnectionsDesc:
+			connections[r.labels["database"]+"/"+r.labels["application_name"]] = r.value
+		case listsMap["used_clients"]:
+			usedClients = r.value
+		}
+	}
+
+	convey.Convey("The exporter's admin console connection is not counted", t, func() {
+		convey.So(connections, convey.ShouldResemble, map[string]float64{
+			"pgbouncer/psql":                  1,
+			"mydb/" + exporterApplicationName: 1,
+		})
+		convey.So(usedClients, convey.ShouldEqual, 2)
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestListsCollectorOwnConnections(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	e := &Exporter{
+		logger:        slog.Default(),
+		clientOptions: ClientOptions{ownApplicationName: exporterApplicationName},
+	}
+	lists := factories["lists"](e)
+	usedClients := func(ctx context.Context) float64 {
+		ch := make(chan prometheus.Metric, 10)
+		if err := lists.Update(ctx, ch, db); err != nil {
+			t.Errorf("Error running the lists collector: %s", err)
+		}
+		close(ch)
+		for m := range ch {
+			if m.Desc() == listsMap["used_clients"] {
+				return readMetric(m).value
+			}
+		}
+		return -1
+	}
+
+	convey.Convey("Without the clients collector only the own connection is left out", t, func() {
+		mock.ExpectQuery("SHOW LISTS;").WillReturnRows(
+			sqlmock.NewRows([]string{"list", "items"}).AddRow("used_clients", 4))
+		convey.So(usedClients(withScrapeState(context.Background())), convey.ShouldEqual, 3)
+	})
+
+	convey.Convey("Own connections counted by the clients collector are left out", t, func() {
+		ctx := withScrapeState(context.Background())
+		scrapeStateFrom(ctx).setOwnConnections(2)
+		mock.ExpectQuery("SHOW LISTS;").WillReturnRows(
+			sqlmock.NewRows([]string{"list", "items"}).AddRow("used_clients", 4))
+		convey.So(usedClients(ctx), convey.ShouldEqual, 2)
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestClientCollectorsShareShowClients(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW CLIENTS;").WillReturnRows(
+		sqlmock.NewRows([]string{"type", "user", "database", "state", "addr", "port", "application_name"}).
+			AddRow("C", "alice", "mydb", "active", "10.0.1.5", 5432, "psql").
+			AddRow("C", "alice", "mydb", "idle", "10.0.2.7", 5432, "psql"))
+
+	e := &Exporter{
+		logger:        slog.Default(),
+		clientOptions: ClientOptions{SourcePrefixV4: 24, SourcePrefixV6: 64},
+	}
+	ctx := withScrapeState(context.Background())
+	ch := make(chan prometheus.Metric, 100)
+	for _, name := range []string{"client_sources", "clients"} {
+		if err := factories[name](e).Update(ctx, ch, db); err != nil {
+			t.Errorf("Error running the %s collector: %s", name, err)
+		}
+	}
+	close(ch)
+
+	series := map[*prometheus.Desc]int{}
+	for m := range ch {
+		series[m.Desc()]++
+	}
+
+	convey.Convey("The client collectors of a scrape share one SHOW CLIENTS", t, func() {
+		convey.So(series[clientSourceConnectionsDesc], convey.ShouldEqual, 2)
+		convey.So(series[clientConnectionsDesc], convey.ShouldEqual, 2)
+		convey.So(mock.ExpectationsWereMet(), convey.ShouldBeNil)
+	})
+}
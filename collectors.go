@@ -0,0 +1,283 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is the interface a collector has to implement.
+type Collector interface {
+	// Get new metrics and expose them via prometheus registry.
+	Update(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error
+}
+
+// collectorFunc adapts a query function to the Collector interface.
+type collectorFunc func(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error
+
+func (f collectorFunc) Update(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error {
+	return f(ctx, ch, db)
+}
+
+const (
+	defaultEnabled  = true
+	defaultDisabled = false
+)
+
+var (
+	factories      = make(map[string]func(e *Exporter) Collector)
+	collectorState = make(map[string]*bool)
+)
+
+func registerCollector(collector string, isDefaultEnabled bool, factory func(e *Exporter) Collector) {
+	var helpDefaultState string
+	if isDefaultEnabled {
+		helpDefaultState = "enabled"
+	} else {
+		helpDefaultState = "disabled"
+	}
+
+	flagName := fmt.Sprintf("collector.%s", collector)
+	flagHelp := fmt.Sprintf("Enable the %s collector (default: %s).", collector, helpDefaultState)
+	defaultValue := fmt.Sprintf("%v", isDefaultEnabled)
+
+	flag := kingpin.Flag(flagName, flagHelp).Default(defaultValue).Bool()
+	collectorState[collector] = flag
+
+	factories[collector] = factory
+}
+
+func init() {
+	registerCollector("version", defaultEnabled, func(e *Exporter) Collector {
+		return collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric, _ *sql.DB) error {
+			// The output of SHOW VERSION is read once per connection for
+			// the column rewrites as well.
+			version, err := e.detectVersion(ctx)
+			if err != nil {
+				return err
+			}
+			ch <- prometheus.MustNewConstMetric(bouncerVersionDesc, prometheus.GaugeValue, 1, version)
+			return nil
+		})
+	})
+	registerCollector("state", defaultEnabled, func(e *Exporter) Collector {
+		return collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error {
+			// SHOW STATE is not available before PgBouncer 1.23.
+			if v := e.detectedVersion(); v.known() && v.before(bouncerVersion{1, 23, 0}) {
+				return nil
+			}
+			err := queryShowState(ctx, ch, db, e.logger)
+			// The version may be unknown, so an older PgBouncer still gets
+			// here.
+			if err != nil && !e.detectedVersion().known() && isBadShowArg(err) {
+				e.logger.Debug("error getting SHOW STATE", "err", err.Error())
+				return nil
+			}
+			return err
+		})
+	})
+	registerCollector("lists", defaultEnabled, func(e *Exporter) Collector {
+		return collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error {
+			// The admin console connections of exporters are used clients,
+			// which the client metrics leave out. Without the count of the
+			// clients collector, only the connection of this exporter is
+			// known.
+			own, ok := scrapeStateFrom(ctx).ownConnections()
+			if !ok && e.clientOptions.ownApplicationName != "" {
+				own = 1
+			}
+			return queryShowLists(ctx, ch, db, own, e.logger)
+		})
+	})
+	registerCollector("config", defaultEnabled, func(e *Exporter) Collector {
+		return collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error {
+			return queryShowConfig(ctx, ch, db, e.logger)
+		})
+	})
+	registerCollector("clients", defaultEnabled, func(e *Exporter) Collector {
+		return collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error {
+			own, err := queryShowClients(ctx, ch, db, e.clientOptions, e.logger)
+			if err != nil {
+				return err
+			}
+			scrapeStateFrom(ctx).setOwnConnections(own)
+			return nil
+		})
+	})
+	registerCollector("client_sources", defaultDisabled, func(e *Exporter) Collector {
+		return collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error {
+			return queryShowClientSources(ctx, ch, db, e.clientOptions, e.logger)
+		})
+	})
+	registerCollector("servers", defaultEnabled, func(e *Exporter) Collector {
+		return collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error {
+			return queryShowServers(ctx, ch, db, e.filter, e.users, e.logger)
+		})
+	})
+	registerCollector("mem", defaultEnabled, func(e *Exporter) Collector {
+		return collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error {
+			return queryShowMem(ctx, ch, db, e.logger)
+		})
+	})
+	registerCollector("pool_sampler", defaultDisabled, func(e *Exporter) Collector {
+		descs, err := poolSamplerDescs(e.columnMappings, e.samplerConfig.Columns)
+		if err != nil || e.samplerConfig.Interval <= 0 {
+			e.logger.Error("invalid pool sampler configuration", "err", err, "interval", e.samplerConfig.Interval)
+			return collectorFunc(func(context.Context, chan<- prometheus.Metric, *sql.DB) error {
+				return nil
+			})
+		}
+		e.sampler = newPoolSampler(e.samplerConfig, descs, e.filter, e.users, e.detectedVersion, e.db, e.logger)
+		e.sampler.start()
+		return e.sampler
+	})
+
+	// Built-in namespaces can be toggled like any other collector. Namespaces
+	// added by a mappings file are always collected.
+	for ns := range metricMaps {
+		registerCollector(ns, defaultEnabled, func(e *Exporter) Collector {
+			return namespaceCollector(e, ns)
+		})
+	}
+}
+
+type scrapeStateKey struct{}
+
+// scrapeState holds what a collector learns for the collectors running after
+// it in the same scrape. The collectors of a scrape run one at a time.
+type scrapeState struct {
+	own     float64
+	ownSeen bool
+	bouncer bouncerClock
+	clients *clientRows
+}
+
+// withScrapeState returns a context carrying a new scrapeState.
+func withScrapeState(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scrapeStateKey{}, &scrapeState{})
+}
+
+// scrapeStateFrom returns the scrapeState of ctx, or nil outside of a scrape.
+func scrapeStateFrom(ctx context.Context) *scrapeState {
+	s, _ := ctx.Value(scrapeStateKey{}).(*scrapeState)
+	return s
+}
+
+// setOwnConnections records the number of admin console connections of
+// exporters seen in SHOW CLIENTS.
+func (s *scrapeState) setOwnConnections(n float64) {
+	if s != nil {
+		s.own, s.ownSeen = n, true
+	}
+}
+
+// ownConnections returns the number of admin console connections of exporters,
+// if SHOW CLIENTS ran earlier in the scrape.
+func (s *scrapeState) ownConnections() (float64, bool) {
+	if s == nil {
+		return 0, false
+	}
+	return s.own, s.ownSeen
+}
+
+// setClientRows records the output of SHOW CLIENTS.
+func (s *scrapeState) setClientRows(c *clientRows) {
+	if s != nil {
+		s.clients = c
+	}
+}
+
+// clientRows returns the output of SHOW CLIENTS, if it was read earlier in the
+// scrape.
+func (s *scrapeState) clientRows() *clientRows {
+	if s == nil {
+		return nil
+	}
+	return s.clients
+}
+
+// setClock records the clock of PgBouncer read from SHOW CLIENTS.
+func (s *scrapeState) setClock(c bouncerClock) {
+	if s != nil {
+		s.bouncer = c
+	}
+}
+
+// clock returns the clock of PgBouncer, unknown unless SHOW CLIENTS ran
+// earlier in the scrape.
+func (s *scrapeState) clock() bouncerClock {
+	if s == nil {
+		return bouncerClock{}
+	}
+	return s.bouncer
+}
+
+// namespaceCollector queries a SHOW namespace through its column mappings.
+func namespaceCollector(e *Exporter, ns string) Collector {
+	return collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error {
+		mapping, ok := e.metricMap[ns]
+		if !ok {
+			return nil
+		}
+		e.logger.Debug("Querying namespace", "namespace", ns)
+		nonFatalErrors, err := queryNamespaceMapping(ctx, ch, db, ns, mapping, e.detectedVersion(), e.filter, e.users, e.logger)
+		// Non-serious errors - likely version or parsing problems.
+		for _, err := range nonFatalErrors {
+			e.logger.Info("error parsing", "err", err.Error())
+		}
+		return err
+	})
+}
+
+// newCollectors instantiates the enabled collectors of the exporter, plus a
+// collector for each namespace without a collector flag.
+func newCollectors(e *Exporter) map[string]Collector {
+	collectors := make(map[string]Collector)
+	for name, enabled := range collectorState {
+		if *enabled {
+			collectors[name] = factories[name](e)
+		}
+	}
+	for ns := range e.metricMap {
+		if _, ok := factories[ns]; !ok {
+			collectors[ns] = namespaceCollector(e, ns)
+		}
+	}
+	return collectors
+}
+
+// enabledCollectors returns the sorted names of the collectors enabled by flags.
+func enabledCollectors() []string {
+	var names []string
+	for name, enabled := range collectorState {
+		if *enabled {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// sortedCollectorNames returns the collector names in a stable order, so that
+// scrapes query PgBouncer in the same order every time.
+func sortedCollectorNames(collectors map[string]Collector) []string {
+	return slices.Sorted(maps.Keys(collectors))
+}
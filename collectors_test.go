@@ -0,0 +1,65 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestCollectorFlags(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--no-collector.clients", "--no-collector.servers", "--collector.client_sources"}); err != nil {
+		t.Fatalf("Error parsing flags: %s", err)
+	}
+	t.Cleanup(func() {
+		// Restore the flag defaults for other tests.
+		if _, err := kingpin.CommandLine.Parse(nil); err != nil {
+			t.Errorf("Error resetting flags: %s", err)
+		}
+	})
+
+	e := NewExporter("postgres://localhost:6432/pgbouncer", namespace, slog.Default(),
+		WithColumnMappings(mergeColumnMappings(metricMaps, map[string]map[string]ColumnMapping{
+			"peers": {"peer_id": {usage: LABEL}},
+		})),
+	)
+
+	convey.Convey("Collectors follow their flags", t, func() {
+		convey.So(e.collectors, convey.ShouldNotContainKey, "clients")
+		convey.So(e.collectors, convey.ShouldNotContainKey, "servers")
+		convey.So(e.collectors, convey.ShouldContainKey, "mem")
+		convey.So(e.collectors, convey.ShouldContainKey, "client_sources")
+		convey.So(e.collectors, convey.ShouldContainKey, "lists")
+		convey.So(e.collectors, convey.ShouldContainKey, "pools")
+		convey.So(e.collectors, convey.ShouldContainKey, "peers")
+		convey.So(enabledCollectors(), convey.ShouldNotContain, "clients")
+	})
+}
+
+func TestCollectorFlagsDefaults(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse(nil); err != nil {
+		t.Fatalf("Error parsing flags: %s", err)
+	}
+
+	convey.Convey("Collectors follow their defaults", t, func() {
+		convey.So(enabledCollectors(), convey.ShouldContain, "clients")
+		convey.So(enabledCollectors(), convey.ShouldContain, "servers")
+		convey.So(enabledCollectors(), convey.ShouldContain, "mem")
+		convey.So(enabledCollectors(), convey.ShouldNotContain, "client_sources")
+		convey.So(enabledCollectors(), convey.ShouldNotContain, "pool_sampler")
+	})
+}
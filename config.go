@@ -0,0 +1,293 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"go.yaml.in/yaml/v2"
+)
+
+// Config is the content of the file given by --config.file.
+type Config struct {
+	AuthModules    map[string]AuthModule `yaml:"auth_modules"`
+	Targets        map[string]Target     `yaml:"targets"`
+	ClientRewrites []RewriteRule         `yaml:"client_rewrites"`
+
+	UserAnonymization UserAnonymization `yaml:"user_anonymization"`
+}
+
+// AuthModule holds reusable credentials for connecting to PgBouncer.
+type AuthModule struct {
+	Type     string   `yaml:"type"`
+	UserPass UserPass `yaml:"userpass"`
+}
+
+// UserPass holds a user and password, either inline or read from a file.
+type UserPass struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// Target is a named PgBouncer instance. It is given either as a complete
+// connection string, or as host and port with optional credentials.
+type Target struct {
+	DSN          string            `yaml:"dsn"`
+	Host         string            `yaml:"host"`
+	Port         uint16            `yaml:"port"`
+	Database     string            `yaml:"database"`
+	User         string            `yaml:"user"`
+	PasswordFile string            `yaml:"password_file"`
+	AuthModule   string            `yaml:"auth_module"`
+	Options      map[string]string `yaml:"options"`
+	// Filters override the global database and user filters.
+	Filters FilterConfig `yaml:"filters"`
+}
+
+// RewriteRule rewrites a label value of the client metrics, before clients
+// are aggregated. Rules are applied in order, each to the result of the
+// previous ones.
+type RewriteRule struct {
+	// Label is application_name, the default, or user.
+	Label string `yaml:"label"`
+	// Regex must match the whole value for the rule to apply.
+	Regex string `yaml:"regex"`
+	// Replacement may refer to capture groups of Regex, it defaults to $1.
+	Replacement string `yaml:"replacement"`
+
+	re *regexp.Regexp
+}
+
+// loadConfig reads and validates the configuration file.
+func loadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(content, c); err != nil {
+		return nil, fmt.Errorf("error parsing config file: %w", err)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	for name, module := range c.AuthModules {
+		if err := module.validate(); err != nil {
+			return fmt.Errorf("auth module %q: %w", name, err)
+		}
+	}
+	if _, err := c.UserAnonymization.anonymizer(); err != nil {
+		return fmt.Errorf("user anonymization: %w", err)
+	}
+	for i := range c.ClientRewrites {
+		if err := c.ClientRewrites[i].compile(); err != nil {
+			return fmt.Errorf("client rewrite %d: %w", i, err)
+		}
+	}
+	for name, target := range c.Targets {
+		if target.DSN != "" && (target.Host != "" || target.Port != 0 || target.Database != "" || len(target.Options) > 0) {
+			return fmt.Errorf("target %q: dsn and host, port, database or options are mutually exclusive", name)
+		}
+		if target.DSN == "" && target.Host == "" {
+			return fmt.Errorf("target %q: one of dsn or host is required", name)
+		}
+		// Resolving the target checks the auth module and password file.
+		if _, err := c.targetConfig(name, ""); err != nil {
+			return err
+		}
+		if _, err := c.targetFilter(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RewriteRule) compile() error {
+	switch r.Label {
+	case "":
+		r.Label = "application_name"
+	case "application_name", "user":
+	default:
+		return fmt.Errorf("unsupported label %q", r.Label)
+	}
+	if r.Regex == "" {
+		return errors.New("regex is required")
+	}
+	if r.Replacement == "" {
+		r.Replacement = "$1"
+	}
+	re, err := compileAnchored(r.Regex)
+	if err != nil {
+		return fmt.Errorf("invalid regex: %w", err)
+	}
+	r.re = re
+	return nil
+}
+
+// rewriteLabel applies the rules for label to value.
+func rewriteLabel(rules []RewriteRule, label, value string) string {
+	for _, r := range rules {
+		if r.Label != label {
+			continue
+		}
+		if m := r.re.FindStringSubmatchIndex(value); m != nil {
+			value = string(r.re.ExpandString(nil, r.Replacement, value, m))
+		}
+	}
+	return value
+}
+
+func (m AuthModule) validate() error {
+	switch m.Type {
+	case "userpass":
+		if m.UserPass.Username == "" {
+			return errors.New("userpass.username is required")
+		}
+		if m.UserPass.Password != "" && m.UserPass.PasswordFile != "" {
+			return errors.New("userpass.password and userpass.password_file are mutually exclusive")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", m.Type)
+	}
+	return nil
+}
+
+// apply sets the credentials of the auth module on the connection config.
+func (m AuthModule) apply(cfg *pq.Config) error {
+	cfg.User = m.UserPass.Username
+	cfg.Password = m.UserPass.Password
+	if m.UserPass.PasswordFile != "" {
+		password, err := readPasswordFile(m.UserPass.PasswordFile)
+		if err != nil {
+			return err
+		}
+		cfg.Password = password
+	}
+	return nil
+}
+
+// targetConfig builds the connection config for the named target. A non-empty
+// authModule overrides the auth module of the target.
+func (c *Config) targetConfig(name, authModule string) (pq.Config, error) {
+	target, ok := c.Targets[name]
+	if !ok {
+		return pq.Config{}, fmt.Errorf("unknown target %q", name)
+	}
+
+	cfg, err := pq.NewConfig(target.connectionString())
+	if err != nil {
+		return pq.Config{}, fmt.Errorf("target %q: error parsing connection parameters: %w", name, err)
+	}
+
+	if target.User != "" {
+		cfg.User = target.User
+	}
+	if target.PasswordFile != "" {
+		password, err := readPasswordFile(target.PasswordFile)
+		if err != nil {
+			return pq.Config{}, fmt.Errorf("target %q: %w", name, err)
+		}
+		cfg.Password = password
+	}
+
+	if authModule == "" {
+		authModule = target.AuthModule
+	}
+	if authModule != "" {
+		if err := c.applyAuthModule(authModule, &cfg); err != nil {
+			return pq.Config{}, fmt.Errorf("target %q: %w", name, err)
+		}
+	}
+	return cfg, nil
+}
+
+// targetFilter returns the database and user filter of the named target.
+func (c *Config) targetFilter(name string) (LabelFilter, error) {
+	target, ok := c.Targets[name]
+	if !ok {
+		return LabelFilter{}, fmt.Errorf("unknown target %q", name)
+	}
+	f, err := target.Filters.compile()
+	if err != nil {
+		return LabelFilter{}, fmt.Errorf("target %q: %w", name, err)
+	}
+	return f, nil
+}
+
+// applyAuthModule sets the credentials of the named auth module on the
+// connection config.
+func (c *Config) applyAuthModule(name string, cfg *pq.Config) error {
+	module, ok := c.AuthModules[name]
+	if !ok {
+		return fmt.Errorf("unknown auth module %q", name)
+	}
+	return module.apply(cfg)
+}
+
+// connectionString returns the key=value connection string of the target,
+// without credentials.
+func (t Target) connectionString() string {
+	if t.DSN != "" {
+		return t.DSN
+	}
+
+	params := map[string]string{
+		"host":   t.Host,
+		"dbname": "pgbouncer",
+	}
+	if t.Port != 0 {
+		params["port"] = strconv.Itoa(int(t.Port))
+	}
+	if t.Database != "" {
+		params["dbname"] = t.Database
+	}
+	for k, v := range t.Options {
+		params[k] = v
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	kvs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, k+"='"+escaper.Replace(params[k])+"'")
+	}
+	return strings.Join(kvs, " ")
+}
+
+func readPasswordFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading password file: %w", err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
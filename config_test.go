@@ -0,0 +1,170 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Error writing %s: %s", name, err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	passwordFile := writeTestFile(t, "password", "s3cret\n")
+	configFile := writeTestFile(t, "config.yml", `
+auth_modules:
+  monitoring:
+    type: userpass
+    userpass:
+      username: stats
+      password_file: `+passwordFile+`
+targets:
+  bouncer-1:
+    host: 10.0.0.1
+    port: 6432
+    auth_module: monitoring
+    options:
+      sslmode: disable
+    filters:
+      database_include: 'tenant_.*'
+  bouncer-2:
+    dsn: postgres://admin@10.0.0.2:6432/pgbouncer?sslmode=disable
+    password_file: `+passwordFile+`
+`)
+
+	conf, err := loadConfig(configFile)
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+
+	convey.Convey("Host targets resolve credentials from their auth module", t, func() {
+		cfg, err := conf.targetConfig("bouncer-1", "")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(cfg.Host, convey.ShouldEqual, "10.0.0.1")
+		convey.So(cfg.Port, convey.ShouldEqual, 6432)
+		convey.So(cfg.Database, convey.ShouldEqual, "pgbouncer")
+		convey.So(cfg.User, convey.ShouldEqual, "stats")
+		convey.So(cfg.Password, convey.ShouldEqual, "s3cret")
+	})
+
+	convey.Convey("DSN targets read the password file", t, func() {
+		cfg, err := conf.targetConfig("bouncer-2", "")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(cfg.Host, convey.ShouldEqual, "10.0.0.2")
+		convey.So(cfg.User, convey.ShouldEqual, "admin")
+		convey.So(cfg.Password, convey.ShouldEqual, "s3cret")
+	})
+
+	convey.Convey("An auth module overrides the target credentials", t, func() {
+		cfg, err := conf.targetConfig("bouncer-2", "monitoring")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(cfg.User, convey.ShouldEqual, "stats")
+	})
+
+	convey.Convey("Targets have their own filters", t, func() {
+		f, err := conf.targetFilter("bouncer-1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(f.allowsDatabase("tenant_1"), convey.ShouldBeTrue)
+		convey.So(f.allowsDatabase("postgres"), convey.ShouldBeFalse)
+		f, err = conf.targetFilter("bouncer-2")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(f, convey.ShouldResemble, LabelFilter{})
+	})
+
+	convey.Convey("Unknown auth modules are rejected", t, func() {
+		_, err := conf.targetConfig("bouncer-1", "missing")
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestClientRewrites(t *testing.T) {
+	conf, err := loadConfig(writeTestFile(t, "config.yml", `
+client_rewrites:
+  - regex: '(web-api)-[0-9a-f]+'
+  - regex: 'web-(.*)'
+    replacement: '$1-service'
+  - label: user
+    regex: 'svc_(.*)'
+`))
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+
+	convey.Convey("Rules apply in order to whole values of their label", t, func() {
+		convey.So(rewriteLabel(conf.ClientRewrites, "application_name", "web-api-1a2b"), convey.ShouldEqual, "api-service")
+		convey.So(rewriteLabel(conf.ClientRewrites, "application_name", "my-web-api-1a2b"), convey.ShouldEqual, "my-web-api-1a2b")
+		convey.So(rewriteLabel(conf.ClientRewrites, "application_name", "svc_billing"), convey.ShouldEqual, "svc_billing")
+		convey.So(rewriteLabel(conf.ClientRewrites, "user", "svc_billing"), convey.ShouldEqual, "billing")
+	})
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown auth module": `
+targets:
+  bouncer-1:
+    host: 10.0.0.1
+    auth_module: missing
+`,
+		"missing host": `
+targets:
+  bouncer-1:
+    port: 6432
+`,
+		"unknown field": `
+targets:
+  bouncer-1:
+    host: 10.0.0.1
+    hostname: bouncer
+`,
+		"invalid rewrite regex": `
+client_rewrites:
+  - regex: '(unclosed'
+`,
+		"invalid target filter": `
+targets:
+  bouncer-1:
+    host: 10.0.0.1
+    filters:
+      user_exclude: '(unclosed'
+`,
+		"unsupported rewrite label": `
+client_rewrites:
+  - label: database
+    regex: 'db-.*'
+`,
+		"missing password file": `
+targets:
+  bouncer-1:
+    host: 10.0.0.1
+    password_file: /nonexistent/password
+`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadConfig(writeTestFile(t, "config.yml", content)); err == nil {
+				t.Errorf("Expected an error loading config with %s", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// backoffConnector wraps the connector of a target. It records when the
+// current connection was established and how often it had to be
+// re-established, and refuses to dial again until an exponentially growing
+// backoff has passed after a failed attempt.
+type backoffConnector struct {
+	connector driver.Connector
+	logger    *slog.Logger
+	now       func() time.Time
+
+	mtx         sync.Mutex
+	connectedAt time.Time
+	reconnects  float64
+	backoff     time.Duration
+	nextAttempt time.Time
+	lastErr     error
+}
+
+func newBackoffConnector(connector driver.Connector, logger *slog.Logger) *backoffConnector {
+	return &backoffConnector{
+		connector: connector,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+// Connect implements driver.Connector.
+func (c *backoffConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.now()
+	if now.Before(c.nextAttempt) {
+		return nil, fmt.Errorf("waiting %s before reconnecting: %w", c.nextAttempt.Sub(now).Round(time.Millisecond), c.lastErr)
+	}
+
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		c.backoff = min(max(2*c.backoff, minReconnectBackoff), maxReconnectBackoff)
+		c.nextAttempt = c.now().Add(c.backoff)
+		c.lastErr = err
+		c.logger.Debug("Connecting to pgbouncer failed", "backoff", c.backoff, "err", err)
+		return nil, err
+	}
+
+	if !c.connectedAt.IsZero() {
+		c.reconnects++
+		c.logger.Debug("Reconnected to pgbouncer", "reconnects", c.reconnects)
+	}
+	c.connectedAt = c.now()
+	c.backoff = 0
+	c.nextAttempt = time.Time{}
+	c.lastErr = nil
+	return conn, nil
+}
+
+// Driver implements driver.Connector.
+func (c *backoffConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+// stats returns the number of reconnects, the age of the current connection
+// and the error of the last connection attempt, if it failed.
+func (c *backoffConnector) stats() (reconnects float64, age time.Duration, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.reconnects, c.now().Sub(c.connectedAt), c.lastErr
+}
+
+// openDB returns a handle keeping a single long-lived connection to the admin
+// console. Broken connections are replaced by database/sql on the next query.
+func openDB(connector *backoffConnector) *sql.DB {
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+	return db
+}
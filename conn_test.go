@@ -0,0 +1,98 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type fakeConn struct{ driver.Conn }
+
+type fakeConnector struct {
+	err   error
+	dials int
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	c.dials++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return fakeConn{}, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver { return nil }
+
+func TestBackoffConnector(t *testing.T) {
+	convey.Convey("Failed connects back off and reconnects are counted", t, func() {
+		now := time.Unix(1700000000, 0)
+		fake := &fakeConnector{}
+		c := newBackoffConnector(fake, slog.Default())
+		c.now = func() time.Time { return now }
+		ctx := context.Background()
+
+		_, err := c.Connect(ctx)
+		convey.So(err, convey.ShouldBeNil)
+		reconnects, _, err := c.stats()
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(reconnects, convey.ShouldEqual, 0)
+
+		fake.err = errors.New("connection refused")
+		_, err = c.Connect(ctx)
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(c.backoff, convey.ShouldEqual, minReconnectBackoff)
+		_, _, err = c.stats()
+		convey.So(err, convey.ShouldEqual, fake.err)
+
+		// Within the backoff the target is not dialed.
+		_, err = c.Connect(ctx)
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(fake.dials, convey.ShouldEqual, 2)
+
+		now = now.Add(minReconnectBackoff)
+		_, err = c.Connect(ctx)
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(c.backoff, convey.ShouldEqual, 2*minReconnectBackoff)
+
+		now = now.Add(2 * minReconnectBackoff)
+		fake.err = nil
+		_, err = c.Connect(ctx)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.backoff, convey.ShouldEqual, 0)
+
+		now = now.Add(time.Minute)
+		reconnects, age, err := c.stats()
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(reconnects, convey.ShouldEqual, 1)
+		convey.So(age, convey.ShouldEqual, time.Minute)
+	})
+
+	convey.Convey("The backoff is capped", t, func() {
+		now := time.Unix(1700000000, 0)
+		c := newBackoffConnector(&fakeConnector{err: errors.New("connection refused")}, slog.Default())
+		c.now = func() time.Time { return now }
+		for range 10 {
+			c.Connect(context.Background())
+			now = now.Add(maxReconnectBackoff)
+		}
+		convey.So(c.backoff, convey.ShouldEqual, maxReconnectBackoff)
+	})
+}
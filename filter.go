@@ -0,0 +1,117 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// LabelFilter selects the databases and users whose rows are exported. Unset
+// expressions match everything, respectively nothing.
+type LabelFilter struct {
+	DatabaseInclude *regexp.Regexp
+	DatabaseExclude *regexp.Regexp
+	UserInclude     *regexp.Regexp
+	UserExclude     *regexp.Regexp
+}
+
+// WithFilter sets the database and user filter of the exporter. Expressions
+// set in f replace those set by an earlier WithFilter.
+func WithFilter(f LabelFilter) ExporterOpt {
+	return func(e *Exporter) {
+		e.filter = e.filter.merge(f)
+	}
+}
+
+// merge returns f with the expressions set in o replacing its own.
+func (f LabelFilter) merge(o LabelFilter) LabelFilter {
+	if o.DatabaseInclude != nil {
+		f.DatabaseInclude = o.DatabaseInclude
+	}
+	if o.DatabaseExclude != nil {
+		f.DatabaseExclude = o.DatabaseExclude
+	}
+	if o.UserInclude != nil {
+		f.UserInclude = o.UserInclude
+	}
+	if o.UserExclude != nil {
+		f.UserExclude = o.UserExclude
+	}
+	return f
+}
+
+// allowsDatabase returns whether rows of the database are exported.
+func (f LabelFilter) allowsDatabase(database string) bool {
+	return allows(f.DatabaseInclude, f.DatabaseExclude, database)
+}
+
+// allowsUser returns whether rows of the user are exported.
+func (f LabelFilter) allowsUser(user string) bool {
+	return allows(f.UserInclude, f.UserExclude, user)
+}
+
+func allows(include, exclude *regexp.Regexp, value string) bool {
+	if include != nil && !include.MatchString(value) {
+		return false
+	}
+	return exclude == nil || !exclude.MatchString(value)
+}
+
+// FilterConfig is the LabelFilter of a target in the configuration file.
+type FilterConfig struct {
+	DatabaseInclude string `yaml:"database_include"`
+	DatabaseExclude string `yaml:"database_exclude"`
+	UserInclude     string `yaml:"user_include"`
+	UserExclude     string `yaml:"user_exclude"`
+}
+
+// compile returns the LabelFilter of the configured expressions, which have
+// to match whole values.
+func (c FilterConfig) compile() (LabelFilter, error) {
+	var f LabelFilter
+	for _, e := range []struct {
+		name string
+		expr string
+		re   **regexp.Regexp
+	}{
+		{"database_include", c.DatabaseInclude, &f.DatabaseInclude},
+		{"database_exclude", c.DatabaseExclude, &f.DatabaseExclude},
+		{"user_include", c.UserInclude, &f.UserInclude},
+		{"user_exclude", c.UserExclude, &f.UserExclude},
+	} {
+		re, err := compileAnchored(e.expr)
+		if err != nil {
+			return LabelFilter{}, fmt.Errorf("invalid %s: %w", e.name, err)
+		}
+		*e.re = re
+	}
+	return f, nil
+}
+
+// namespaceFilterColumns are the columns holding the database and user of
+// namespaces which do not use the database and user columns.
+var namespaceFilterColumns = map[string]struct{ database, user string }{
+	"databases": {database: "name"},
+	"users":     {user: "name"},
+}
+
+// filterColumns returns the columns of a namespace holding the database and
+// user, empty if the namespace has none.
+func filterColumns(namespace string) (database, user string) {
+	if c, ok := namespaceFilterColumns[namespace]; ok {
+		return c.database, c.user
+	}
+	return "database", "user"
+}
@@ -0,0 +1,109 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestLabelFilter(t *testing.T) {
+	global, err := FilterConfig{DatabaseExclude: "template.*", UserInclude: "app_.*"}.compile()
+	if err != nil {
+		t.Fatalf("Error compiling filter: %s", err)
+	}
+	target, err := FilterConfig{UserInclude: "tenant_.*"}.compile()
+	if err != nil {
+		t.Fatalf("Error compiling filter: %s", err)
+	}
+
+	convey.Convey("Expressions match whole values", t, func() {
+		convey.So(global.allowsDatabase("template1"), convey.ShouldBeFalse)
+		convey.So(global.allowsDatabase("mytemplate1"), convey.ShouldBeTrue)
+		convey.So(global.allowsUser("app_web"), convey.ShouldBeTrue)
+		convey.So(global.allowsUser("my_app_web"), convey.ShouldBeFalse)
+	})
+
+	convey.Convey("Target filters replace the expressions they set", t, func() {
+		f := global.merge(target)
+		convey.So(f.allowsDatabase("template1"), convey.ShouldBeFalse)
+		convey.So(f.allowsUser("app_web"), convey.ShouldBeFalse)
+		convey.So(f.allowsUser("tenant_1"), convey.ShouldBeTrue)
+	})
+
+	convey.Convey("Invalid expressions are rejected", t, func() {
+		_, err := FilterConfig{DatabaseInclude: "(unclosed"}.compile()
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestQueryNamespaceMappingFilter(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW users;").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "pool_mode", "max_user_connections", "current_connections"}).
+			AddRow("tenant_1", "", 10, 5).
+			AddRow("postgres", "", 10, 1))
+	mock.ExpectQuery("SHOW pools;").WillReturnRows(
+		sqlmock.NewRows([]string{"database", "user", "cl_waiting"}).
+			AddRow("tenant_db", "tenant_1", 3).
+			AddRow("pgbouncer", "tenant_1", 1).
+			AddRow("tenant_db", "postgres", 2))
+
+	filter, err := FilterConfig{DatabaseInclude: "tenant_.*", UserExclude: "postgres"}.compile()
+	if err != nil {
+		t.Fatalf("Error compiling filter: %s", err)
+	}
+	logger := slog.Default()
+	metricMap := makeDescMap(metricMaps, namespace, logger)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		for _, ns := range []string{"users", "pools"} {
+			if _, err := queryNamespaceMapping(context.Background(), ch, db, ns, metricMap[ns], bouncerVersion{}, filter, nil, logger); err != nil {
+				t.Errorf("Error running queryNamespaceMapping: %s", err)
+			}
+		}
+	}()
+
+	users := map[string]bool{}
+	pools := map[string]bool{}
+	for m := range ch {
+		r := readMetric(m)
+		if name, ok := r.labels["name"]; ok {
+			users[name] = true
+		} else {
+			pools[r.labels["database"]+"/"+r.labels["user"]] = true
+		}
+	}
+
+	convey.Convey("Rows of filtered databases and users are left out", t, func() {
+		convey.So(users, convey.ShouldResemble, map[string]bool{"tenant_1": true})
+		convey.So(pools, convey.ShouldResemble, map[string]bool{"tenant_db/tenant_1": true})
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
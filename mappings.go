@@ -0,0 +1,98 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// Namespaces are interpolated into SHOW statements, so only plain identifiers
+// are accepted.
+var mappingNamespaceRE = regexp.MustCompile(`^[a-z_]+$`)
+
+// keyColumns are the label columns telling apart the rows of a built-in
+// namespace. Discarding one of them would give several rows the same labels.
+var keyColumns = map[string][]string{
+	"databases":      {"name"},
+	"stats_totals":   {"database"},
+	"stats_averages": {"database"},
+	"users":          {"name"},
+	"pools":          {"database", "user"},
+}
+
+// loadColumnMappings reads user-defined column mappings, keyed by SHOW
+// namespace and column name.
+func loadColumnMappings(path string) (map[string]map[string]ColumnMapping, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading mappings file: %w", err)
+	}
+
+	mappings := make(map[string]map[string]ColumnMapping)
+	if err := yaml.UnmarshalStrict(content, &mappings); err != nil {
+		return nil, fmt.Errorf("error parsing mappings file: %w", err)
+	}
+
+	for namespace, columns := range mappings {
+		if !mappingNamespaceRE.MatchString(namespace) {
+			return nil, fmt.Errorf("invalid namespace %q in mappings file", namespace)
+		}
+		// A namespace named after another collector would never be queried.
+		if _, ok := factories[namespace]; ok {
+			if _, ok := metricMaps[namespace]; !ok {
+				return nil, fmt.Errorf("namespace %q in mappings file is the name of a collector", namespace)
+			}
+		}
+		for column, mapping := range columns {
+			if mapping.usage != LABEL && mapping.usage != DISCARD && mapping.metric == "" {
+				return nil, fmt.Errorf("missing metric for column %q in namespace %q", column, namespace)
+			}
+			if mapping.usage == MAPPEDMETRIC && len(mapping.mapping) == 0 {
+				return nil, fmt.Errorf("missing metric_mapping for column %q in namespace %q", column, namespace)
+			}
+			if mapping.usage == DISCARD && slices.Contains(keyColumns[namespace], column) {
+				return nil, fmt.Errorf("column %q in namespace %q identifies its rows and cannot be discarded", column, namespace)
+			}
+		}
+	}
+	return mappings, nil
+}
+
+// mergeColumnMappings returns the base mappings with the overrides applied.
+// Columns of an override replace the column of the same name in the base
+// namespace, and unknown namespaces are added. The inputs are not modified.
+func mergeColumnMappings(base, overrides map[string]map[string]ColumnMapping) map[string]map[string]ColumnMapping {
+	merged := make(map[string]map[string]ColumnMapping, len(base))
+	for namespace, columns := range base {
+		merged[namespace] = make(map[string]ColumnMapping, len(columns))
+		for column, mapping := range columns {
+			merged[namespace][column] = mapping
+		}
+	}
+
+	for namespace, columns := range overrides {
+		if _, ok := merged[namespace]; !ok {
+			merged[namespace] = make(map[string]ColumnMapping, len(columns))
+		}
+		for column, mapping := range columns {
+			merged[namespace][column] = mapping
+		}
+	}
+	return merged
+}
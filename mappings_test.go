@@ -0,0 +1,108 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoadColumnMappings(t *testing.T) {
+	path := writeTestFile(t, "mappings.yml", `
+pools:
+  cl_active:
+    usage: GAUGE
+    metric: client_active
+    description: Active clients
+  cl_new:
+    usage: GAUGE
+    metric: client_new_connections
+    description: New clients
+peers:
+  peer_id:
+    usage: LABEL
+  pool_size:
+    usage: GAUGE
+    metric: pool_size
+    factor: 2
+    description: Peer pool size
+`)
+
+	userMappings, err := loadColumnMappings(path)
+	if err != nil {
+		t.Fatalf("Error loading mappings: %s", err)
+	}
+	merged := mergeColumnMappings(metricMaps, userMappings)
+
+	convey.Convey("User mappings override and extend the built-in mappings", t, func() {
+		convey.So(merged["pools"]["cl_active"].metric, convey.ShouldEqual, "client_active")
+		convey.So(merged["pools"]["cl_new"].factor, convey.ShouldEqual, 1)
+		convey.So(merged["pools"]["cl_waiting"].metric, convey.ShouldEqual, "client_waiting_connections")
+		convey.So(merged["peers"]["pool_size"].factor, convey.ShouldEqual, 2)
+		convey.So(metricMaps["pools"]["cl_active"].metric, convey.ShouldEqual, "client_active_connections")
+		convey.So(metricMaps, convey.ShouldNotContainKey, "peers")
+	})
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"peer_id", "pool_size"}).
+		AddRow(1, 10)
+	mock.ExpectQuery("SHOW peers;").WillReturnRows(rows)
+
+	logger := slog.Default()
+	metricMap := makeDescMap(merged, namespace, logger)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if _, err := queryNamespaceMapping(context.Background(), ch, db, "peers", metricMap["peers"], bouncerVersion{}, LabelFilter{}, nil, logger); err != nil {
+			t.Errorf("Error running queryNamespaceMapping: %s", err)
+		}
+	}()
+
+	convey.Convey("User defined namespaces are queried", t, func() {
+		m := readMetric(<-ch)
+		convey.So(m, convey.ShouldResemble, MetricResult{labels: labelMap{"peer_id": "1"}, metricType: dto.MetricType_GAUGE, value: 20})
+	})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled exceptions: %s", err)
+	}
+}
+
+func TestLoadColumnMappingsInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown usage":     "pools:\n  cl_active:\n    usage: HISTOGRAM\n    metric: x\n",
+		"missing usage":     "pools:\n  cl_active:\n    metric: x\n",
+		"missing metric":    "pools:\n  cl_active:\n    usage: GAUGE\n",
+		"invalid namespace": "\"pools; RELOAD\":\n  cl_active:\n    usage: GAUGE\n    metric: x\n",
+		"discarded key":     "pools:\n  user:\n    usage: DISCARD\n",
+		"collector name":    "servers:\n  state:\n    usage: LABEL\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadColumnMappings(writeTestFile(t, "mappings.yml", content)); err == nil {
+				t.Errorf("Expected an error loading mappings with %s", name)
+			}
+		})
+	}
+}
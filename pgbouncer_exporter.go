@@ -14,14 +14,18 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/lib/pq"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/promslog"
 	"github.com/prometheus/common/promslog/flag"
 	"github.com/prometheus/common/version"
@@ -48,6 +52,30 @@ func main() {
 		connectionStringPointer = kingpin.Flag("pgBouncer.connectionString", "Connection string for accessing pgBouncer.").Default("postgres://postgres:@localhost:6543/pgbouncer?sslmode=disable").Envar("PGBOUNCER_EXPORTER_CONNECTION_STRING").String()
 		metricsPath             = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
 		pidFilePath             = kingpin.Flag("pgBouncer.pid-file", pidFileHelpText).Default("").String()
+		configFile              = kingpin.Flag("config.file", "Path to the configuration file with targets and auth modules.").Default("").String()
+		configTarget            = kingpin.Flag("config.target", "Name of the target in --config.file scraped on the metrics path, instead of --pgBouncer.connectionString.").Default("").String()
+		configAuthModule        = kingpin.Flag("config.auth-module", "Name of the auth module in --config.file used for --pgBouncer.connectionString.").Default("").String()
+		timeoutOffset           = kingpin.Flag("scrape.timeout-offset", "Offset to subtract from the Prometheus scrape timeout when querying PgBouncer.").Default("500ms").Duration()
+		coalesceWindow          = kingpin.Flag("scrape.coalesce-window", "Share concurrent scrapes and reuse their result for this long. 0 disables coalescing.").Default("0s").Duration()
+		cacheMaxAge             = kingpin.Flag("scrape.cache-max-age", "Serve the metrics of the last successful scrape for up to this long while PgBouncer is unreachable. 0 disables the cache.").Default("0s").Duration()
+		samplerInterval         = kingpin.Flag("sampler.interval", "Interval at which the pool_sampler collector queries SHOW POOLS.").Default("1s").Duration()
+		samplerWindow           = kingpin.Flag("sampler.window", "Window over which the pool_sampler collector aggregates samples.").Default("30s").Duration()
+		samplerColumns          = kingpin.Flag("sampler.columns", "Comma separated SHOW POOLS columns sampled by the pool_sampler collector.").Default("cl_waiting,maxwait").String()
+		checkRewrites           = kingpin.Flag("config.check-rewrite", "Print how the client_rewrites of --config.file rewrite a label=value pair, or an application_name, and exit. Can be repeated.").Strings()
+		clientHistograms        = kingpin.Flag("collector.clients.histograms", "Expose histograms of client wait time and connection age per database and user.").Default("false").Bool()
+		clientMaxAppNames       = kingpin.Flag("collector.clients.max-application-names", "Maximum number of application_name values of client metrics, the rest is folded into \"other\". 0 means no limit.").Default("0").Int()
+		clientMaxUsers          = kingpin.Flag("collector.clients.max-users", "Maximum number of user values of client metrics, the rest is folded into \"other\". 0 means no limit.").Default("0").Int()
+		clientAppNameAllow      = kingpin.Flag("collector.clients.application-name-allow", "Regexp of application_name values of client metrics to keep, others are folded into \"other\".").Default("").String()
+		clientAppNameDeny       = kingpin.Flag("collector.clients.application-name-deny", "Regexp of application_name values of client metrics to fold into \"other\".").Default("").String()
+		sourcePrefixV4          = kingpin.Flag("collector.client_sources.ipv4-prefix", "Prefix length IPv4 client addresses are collapsed to.").Default("24").Int()
+		sourcePrefixV6          = kingpin.Flag("collector.client_sources.ipv6-prefix", "Prefix length IPv6 client addresses are collapsed to.").Default("64").Int()
+		maxSources              = kingpin.Flag("collector.client_sources.max-sources", "Maximum number of source values, the rest is folded into \"other\". 0 means no limit.").Default("0").Int()
+		databaseInclude         = kingpin.Flag("filter.database-include", "Regexp of databases to export, others are left out.").Default("").String()
+		databaseExclude         = kingpin.Flag("filter.database-exclude", "Regexp of databases to leave out.").Default("").String()
+		userInclude             = kingpin.Flag("filter.user-include", "Regexp of users to export, others are left out.").Default("").String()
+		userExclude             = kingpin.Flag("filter.user-exclude", "Regexp of users to leave out.").Default("").String()
+		probeMaxTargets         = kingpin.Flag("probe.max-targets", "Maximum number of /probe targets whose connection is kept open. The least recently probed target is closed beyond it.").Default("100").Int()
+		mappingsFile            = kingpin.Flag("mappings.file", "Path to a YAML file with column mappings which override or extend the built-in metrics.").Default("").String()
 	)
 
 	toolkitFlags := kingpinflag.AddFlags(kingpin.CommandLine, ":9127")
@@ -61,13 +89,133 @@ func main() {
 	logger.Info("Starting pgbouncer_exporter", "version", version.Info())
 	logger.Info("Build context", "build_context", version.BuildContext())
 
+	conf := &Config{}
+	if *configFile != "" {
+		var err error
+		conf, err = loadConfig(*configFile)
+		if err != nil {
+			logger.Error("Error loading config", "err", err)
+			os.Exit(1)
+		}
+		logger.Info("Loaded config file", "file", *configFile)
+	}
+
+	if len(*checkRewrites) > 0 {
+		for _, check := range *checkRewrites {
+			label, value, ok := strings.Cut(check, "=")
+			if !ok {
+				label, value = "application_name", check
+			}
+			fmt.Printf("%s=%q -> %q\n", label, value, rewriteLabel(conf.ClientRewrites, label, value))
+		}
+		os.Exit(0)
+	}
+
+	logger.Info("Enabled collectors")
+	for _, name := range enabledCollectors() {
+		logger.Info(name)
+	}
+
+	columnMappings := metricMaps
+	if *mappingsFile != "" {
+		userMappings, err := loadColumnMappings(*mappingsFile)
+		if err != nil {
+			logger.Error("Error loading mappings", "err", err)
+			os.Exit(1)
+		}
+		columnMappings = mergeColumnMappings(metricMaps, userMappings)
+		logger.Info("Loaded mappings file", "file", *mappingsFile)
+	}
+	samplerConfig := PoolSamplerConfig{
+		Interval: *samplerInterval,
+		Window:   *samplerWindow,
+		Columns:  strings.Split(*samplerColumns, ","),
+	}
+	if *collectorState["pool_sampler"] {
+		if _, err := poolSamplerDescs(columnMappings, samplerConfig.Columns); err != nil {
+			logger.Error("Invalid --sampler.columns", "err", err)
+			os.Exit(1)
+		}
+		if samplerConfig.Interval <= 0 || samplerConfig.Window < samplerConfig.Interval {
+			logger.Error("--sampler.interval must be positive and not exceed --sampler.window")
+			os.Exit(1)
+		}
+	}
+	if *probeMaxTargets < 1 {
+		logger.Error("--probe.max-targets must be positive")
+		os.Exit(1)
+	}
+	var err error
+	clientOptions := ClientOptions{
+		Histograms:          *clientHistograms,
+		MaxApplicationNames: *clientMaxAppNames,
+		MaxUsers:            *clientMaxUsers,
+		Rewrites:            conf.ClientRewrites,
+		SourcePrefixV4:      *sourcePrefixV4,
+		SourcePrefixV6:      *sourcePrefixV6,
+		MaxSources:          *maxSources,
+	}
+	if clientOptions.SourcePrefixV4 < 0 || clientOptions.SourcePrefixV4 > 32 || clientOptions.SourcePrefixV6 < 0 || clientOptions.SourcePrefixV6 > 128 {
+		logger.Error("Invalid --collector.client_sources prefix length", "ipv4", clientOptions.SourcePrefixV4, "ipv6", clientOptions.SourcePrefixV6)
+		os.Exit(1)
+	}
+	if clientOptions.ApplicationNameAllow, err = compileAnchored(*clientAppNameAllow); err != nil {
+		logger.Error("Invalid --collector.clients.application-name-allow", "err", err)
+		os.Exit(1)
+	}
+	if clientOptions.ApplicationNameDeny, err = compileAnchored(*clientAppNameDeny); err != nil {
+		logger.Error("Invalid --collector.clients.application-name-deny", "err", err)
+		os.Exit(1)
+	}
+	filter, err := FilterConfig{
+		DatabaseInclude: *databaseInclude,
+		DatabaseExclude: *databaseExclude,
+		UserInclude:     *userInclude,
+		UserExclude:     *userExclude,
+	}.compile()
+	if err != nil {
+		logger.Error("Invalid --filter flag", "err", err)
+		os.Exit(1)
+	}
+	users, err := conf.UserAnonymization.anonymizer()
+	if err != nil {
+		logger.Error("Error loading user anonymization", "err", err)
+		os.Exit(1)
+	}
+	exporterOpts := []ExporterOpt{
+		WithFilter(filter),
+		WithUserAnonymizer(users),
+		WithColumnMappings(columnMappings),
+		WithCoalescing(*coalesceWindow),
+		WithCache(*cacheMaxAge),
+		WithPoolSampler(samplerConfig),
+		WithClientOptions(clientOptions),
+	}
+
 	connectionString := *connectionStringPointer
-	exporter := NewExporter(connectionString, namespace, logger)
-	if exporter == nil {
-		logger.Error("Failed to create exporter")
+	var cfg pq.Config
+	metricsOpts := exporterOpts
+	if *configTarget != "" {
+		cfg, err = conf.targetConfig(*configTarget, *configAuthModule)
+		if err == nil {
+			filter, err = conf.targetFilter(*configTarget)
+			metricsOpts = append(slices.Clip(exporterOpts), WithFilter(filter))
+		}
+	} else {
+		cfg, err = pq.NewConfig(connectionString)
+		if err == nil && *configAuthModule != "" {
+			err = conf.applyAuthModule(*configAuthModule, &cfg)
+		}
+	}
+	if err != nil {
+		logger.Error("Error building connection config", "err", err)
+		os.Exit(1)
+	}
+	exporter, err := newExporter(cfg, namespace, logger, metricsOpts...)
+	if err != nil {
+		logger.Error("Failed to create connector", "err", err)
 		os.Exit(1)
 	}
-	prometheus.MustRegister(exporter)
 	prometheus.MustRegister(versioncollector.NewCollector("pgbouncer_exporter"))
 
 	if *pidFilePath != "" {
@@ -80,7 +228,8 @@ func main() {
 		prometheus.MustRegister(procExporter)
 	}
 
-	http.Handle(*metricsPath, promhttp.Handler())
+	http.Handle(*metricsPath, handleMetrics(exporter, *timeoutOffset, logger))
+	http.HandleFunc("/probe", handleProbe(connectionString, conf, *probeMaxTargets, *timeoutOffset, logger, exporterOpts...))
 	if *metricsPath != "/" && *metricsPath != "" {
 		landingConfig := web.LandingConfig{
 			Name:        "PgBouncer Exporter",
@@ -91,6 +240,10 @@ func main() {
 					Address: *metricsPath,
 					Text:    "Metrics",
 				},
+				{
+					Address: "/probe",
+					Text:    "Probe",
+				},
 			},
 		}
 		landingPage, err := web.NewLandingPage(landingConfig)
@@ -107,3 +260,12 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// compileAnchored compiles a regexp flag matching whole values. An empty
+// expression returns nil.
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
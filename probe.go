@@ -0,0 +1,283 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeIdleTimeout is how long the connection to a probed target is kept
+// after its last probe.
+const probeIdleTimeout = 10 * time.Minute
+
+// handleProbe returns a handler which scrapes the PgBouncer given by the
+// target URL parameter, blackbox exporter style. Each request gets its own
+// registry, so only metrics of the probed target are returned. The Exporter
+// of a target is reused between probes to keep its connection open.
+//
+// The target is either the name of a target in the configuration file, or an
+// address or connection string resolved by targetConfig. The optional
+// auth_module URL parameter selects the credentials from the configuration
+// file. As anyone reaching /probe picks the target, configured credentials
+// are only sent to targets of the configuration file.
+func handleProbe(baseConnectionString string, conf *Config, maxTargets int, timeoutOffset time.Duration, logger *slog.Logger, opts ...ExporterOpt) http.HandlerFunc {
+	exporters := newExporterCache(probeIdleTimeout, maxTargets)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		target := params.Get("target")
+		if target == "" {
+			http.Error(w, "target is required", http.StatusBadRequest)
+			return
+		}
+		authModule := params.Get("auth_module")
+
+		var (
+			cfg        pq.Config
+			err        error
+			targetOpts = opts
+		)
+		if _, ok := conf.Targets[target]; ok {
+			var filter LabelFilter
+			cfg, err = conf.targetConfig(target, authModule)
+			if err == nil {
+				filter, err = conf.targetFilter(target)
+				targetOpts = append(slices.Clip(opts), WithFilter(filter))
+			}
+		} else if authModule != "" {
+			err = fmt.Errorf("auth_module requires a target of the configuration file")
+		} else {
+			cfg, err = targetConfig(baseConnectionString, target)
+		}
+		if err != nil {
+			logger.Error("Error building connection config for target", "target", targetLabel(conf, target, cfg), "err", err)
+			http.Error(w, fmt.Sprintf("invalid target: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		tl := logger.With("target", targetLabel(conf, target, cfg))
+
+		exporter, release, err := exporters.get(target+"\x00"+authModule, func() (*Exporter, error) {
+			return newExporter(cfg, namespace, tl, targetOpts...)
+		})
+		if err != nil {
+			tl.Error("Error creating connector for target", "err", err)
+			http.Error(w, fmt.Sprintf("invalid target: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer release()
+
+		ctx, cancel := scrapeContext(r, timeoutOffset, tl)
+		defer cancel()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(scrapeCollector{ctx: ctx, exporter: exporter})
+
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	}
+}
+
+// targetLabel returns how a probe target is logged. A connection string
+// target can hold a password, so ad-hoc targets are logged by their address.
+func targetLabel(conf *Config, target string, cfg pq.Config) string {
+	if _, ok := conf.Targets[target]; ok {
+		return target
+	}
+	if cfg.Host == "" {
+		return "<invalid>"
+	}
+	return net.JoinHostPort(cfg.Host, strconv.Itoa(int(cfg.Port)))
+}
+
+// exporterCache keeps an Exporter per probed target, closing those which have
+// not been used for longer than the idle timeout. Beyond maxEntries targets,
+// the least recently used one is closed, since every target holds a
+// connection and anyone reaching /probe picks the targets. Exporters are
+// closed outside the lock, once the probes using them are done, as closing
+// waits for the queries in flight.
+type exporterCache struct {
+	idleTimeout time.Duration
+	maxEntries  int
+	now         func() time.Time
+	close       func(*Exporter) error
+
+	mtx       sync.Mutex
+	exporters map[string]*cachedExporter
+}
+
+type cachedExporter struct {
+	exporter *Exporter
+	lastUsed time.Time
+	// users counts the probes using the Exporter. An evicted Exporter is
+	// closed once the last of them is done.
+	users   int
+	evicted bool
+}
+
+func newExporterCache(idleTimeout time.Duration, maxEntries int) *exporterCache {
+	return &exporterCache{
+		idleTimeout: idleTimeout,
+		maxEntries:  maxEntries,
+		now:         time.Now,
+		close:       (*Exporter).Close,
+		exporters:   make(map[string]*cachedExporter),
+	}
+}
+
+// get returns the Exporter cached under key, creating it with newFn if there
+// is none, and a function to call once done with the Exporter.
+func (c *exporterCache) get(key string, newFn func() (*Exporter, error)) (*Exporter, func(), error) {
+	c.mtx.Lock()
+	now := c.now()
+	var closing []*Exporter
+	for k, ce := range c.exporters {
+		if now.Sub(ce.lastUsed) > c.idleTimeout {
+			closing = c.evict(k, closing)
+		}
+	}
+
+	ce, ok := c.exporters[key]
+	if !ok && len(c.exporters) >= c.maxEntries {
+		closing = c.evict(c.leastRecentlyUsed(), closing)
+	}
+	var err error
+	if !ok {
+		var exporter *Exporter
+		if exporter, err = newFn(); err == nil {
+			ce = &cachedExporter{exporter: exporter}
+			c.exporters[key] = ce
+		}
+	}
+	if err == nil {
+		ce.lastUsed = now
+		ce.users++
+	}
+	c.mtx.Unlock()
+
+	for _, e := range closing {
+		c.close(e)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return ce.exporter, func() { c.release(ce) }, nil
+}
+
+// release marks a probe using ce as done, closing the Exporter if it was
+// evicted meanwhile.
+func (c *exporterCache) release(ce *cachedExporter) {
+	c.mtx.Lock()
+	ce.users--
+	ce.lastUsed = c.now()
+	closing := ce.evicted && ce.users == 0
+	c.mtx.Unlock()
+
+	if closing {
+		c.close(ce.exporter)
+	}
+}
+
+// evict removes the Exporter cached under key, adding it to closing unless a
+// probe still uses it.
+func (c *exporterCache) evict(key string, closing []*Exporter) []*Exporter {
+	ce := c.exporters[key]
+	delete(c.exporters, key)
+	ce.evicted = true
+	if ce.users == 0 {
+		closing = append(closing, ce.exporter)
+	}
+	return closing
+}
+
+// leastRecentlyUsed returns the key of the Exporter used least recently.
+func (c *exporterCache) leastRecentlyUsed() string {
+	var oldest string
+	for k, ce := range c.exporters {
+		if oldest == "" || ce.lastUsed.Before(c.exporters[oldest].lastUsed) {
+			oldest = k
+		}
+	}
+	return oldest
+}
+
+// noPassfile is a password file which cannot exist, disabling the .pgpass
+// lookup of lib/pq.
+const noPassfile = "/dev/null/pgpass"
+
+// targetConfig builds the connection config for a probe target. A target is
+// either a complete connection string (URL or key=value form), or a host with
+// an optional port which replaces the host and port of the base connection
+// string, keeping its user, database and other parameters but not its
+// password. The password of a connection string target must be written in
+// it, passwords of the environment, .pgpass and service files are not sent to
+// ad-hoc targets.
+func targetConfig(baseConnectionString, target string) (pq.Config, error) {
+	if strings.Contains(target, "://") || strings.Contains(target, "=") {
+		cfg, err := pq.NewConfig(target)
+		if err != nil {
+			// The error of lib/pq can quote the connection string, password
+			// included.
+			return pq.Config{}, errors.New("invalid connection string")
+		}
+		if cfg.Service != "" {
+			return pq.Config{}, errors.New("service is not supported in probe targets")
+		}
+		// lib/pq merges PGPASSWORD into the config. A password equal to it
+		// cannot be told apart, so it is dropped as well.
+		if env := os.Getenv("PGPASSWORD"); env != "" && cfg.Password == env {
+			cfg.Password = ""
+		}
+		cfg.Passfile = noPassfile
+		return cfg, nil
+	}
+
+	cfg, err := pq.NewConfig(baseConnectionString)
+	if err != nil {
+		return pq.Config{}, fmt.Errorf("error parsing base connection string: %w", err)
+	}
+
+	host, port := target, ""
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		host, port = h, p
+	}
+	if host == "" {
+		return pq.Config{}, fmt.Errorf("missing host in target %q", target)
+	}
+	cfg.Host = host
+	cfg.Password = ""
+	cfg.Passfile = noPassfile
+	if port != "" {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return pq.Config{}, fmt.Errorf("invalid port in target %q: %w", target, err)
+		}
+		cfg.Port = uint16(p)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,297 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolSamplerConfig configures the background sampling of SHOW POOLS.
+type PoolSamplerConfig struct {
+	// Interval between two samples.
+	Interval time.Duration
+	// Window over which the samples are aggregated at scrape time.
+	Window time.Duration
+	// Columns of SHOW POOLS to sample. They must be gauges of the pools
+	// namespace.
+	Columns []string
+}
+
+// WithPoolSampler configures the pool_sampler collector.
+func WithPoolSampler(c PoolSamplerConfig) ExporterOpt {
+	return func(e *Exporter) {
+		e.samplerConfig = c
+	}
+}
+
+// samplerDescs are the descriptors of a sampled column.
+type samplerDescs struct {
+	factor         float64
+	max, min, mean *prometheus.Desc
+}
+
+// poolSamplerDescs returns the descriptors for the sampled columns, which
+// must be gauges in the pools namespace of the column mappings.
+func poolSamplerDescs(mappings map[string]map[string]ColumnMapping, columns []string) (map[string]samplerDescs, error) {
+	descs := make(map[string]samplerDescs, len(columns))
+	for _, column := range columns {
+		cm, ok := mappings["pools"][column]
+		if !ok || cm.usage != GAUGE {
+			return nil, fmt.Errorf("column %q is not a gauge of SHOW POOLS", column)
+		}
+		newDesc := func(agg string) *prometheus.Desc {
+			return prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "pools", cm.metric+"_"+agg+"_over_window"),
+				fmt.Sprintf("%s, %s over the sampling window", cm.description, agg),
+				[]string{"database", "user"}, nil,
+			)
+		}
+		descs[column] = samplerDescs{
+			factor: cm.factor,
+			max:    newDesc("max"),
+			min:    newDesc("min"),
+			mean:   newDesc("mean"),
+		}
+	}
+	return descs, nil
+}
+
+type poolKey struct {
+	database, user string
+}
+
+type poolSample struct {
+	at     time.Time
+	values map[poolKey]map[string]float64
+}
+
+// poolSampler queries SHOW POOLS in the background, so that spikes between
+// two scrapes are not missed. At scrape time it exposes the maximum, minimum
+// and mean of each sampled column over the window.
+type poolSampler struct {
+	config  PoolSamplerConfig
+	descs   map[string]samplerDescs
+	filter  LabelFilter
+	users   *UserAnonymizer
+	version func() bouncerVersion
+	db      *sql.DB
+	logger  *slog.Logger
+	now     func() time.Time
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mtx     sync.Mutex
+	samples []poolSample
+}
+
+func newPoolSampler(config PoolSamplerConfig, descs map[string]samplerDescs, filter LabelFilter, users *UserAnonymizer, version func() bouncerVersion, db *sql.DB, logger *slog.Logger) *poolSampler {
+	return &poolSampler{
+		config:  config,
+		descs:   descs,
+		filter:  filter,
+		users:   users,
+		version: version,
+		db:      db,
+		logger:  logger,
+		now:     time.Now,
+	}
+}
+
+// start samples every interval until stop is called.
+func (s *poolSampler) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.sample(ctx)
+			}
+		}
+	}()
+}
+
+// stop stops sampling and waits for the sample in progress.
+func (s *poolSampler) stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+// sample takes a single sample of SHOW POOLS.
+func (s *poolSampler) sample(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Interval)
+	defer cancel()
+
+	values, err := s.queryPools(ctx)
+	if err != nil {
+		s.logger.Debug("error sampling SHOW POOLS", "err", err.Error())
+		return
+	}
+	s.add(poolSample{at: s.now(), values: values})
+}
+
+func (s *poolSampler) queryPools(ctx context.Context) (map[poolKey]map[string]float64, error) {
+	// Read the version before the query takes the connection, which the
+	// version detection may be waiting for.
+	version := s.version()
+
+	rows, err := s.db.QueryContext(ctx, "SHOW POOLS;")
+	if err != nil {
+		return nil, fmt.Errorf("error running SHOW POOLS on database: %w", err)
+	}
+	defer rows.Close()
+
+	columnNames, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving columns list from SHOW POOLS: %w", err)
+	}
+
+	columnData := make([]interface{}, len(columnNames))
+	scanArgs := make([]interface{}, len(columnNames))
+	for i := range columnData {
+		scanArgs[i] = &columnData[i]
+	}
+
+	// Sample split columns such as maxwait as one value, with the version
+	// detected by the scrapes.
+	columnIdx := make(map[string]int, len(columnNames))
+	for i, n := range columnNames {
+		columnIdx[n] = i
+	}
+	rewrites, rewriteTargets, metricColumns := rewriteColumns(columnRewrites["pools"], version, columnNames, columnIdx)
+
+	sanitize := func(s string) string {
+		if !utf8.ValidString(s) {
+			return "<invalid>"
+		}
+		return s
+	}
+
+	values := make(map[poolKey]map[string]float64)
+	for rows.Next() {
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, fmt.Errorf("error scanning SHOW POOLS row: %w", err)
+		}
+		rowData := slices.Clone(columnData)
+		for i, rewrite := range rewrites {
+			rowData[rewriteTargets[i]] = nil
+			if v, ok := rewrite.combine(columnData, columnIdx); ok {
+				rowData[rewriteTargets[i]] = v
+			}
+		}
+		var key poolKey
+		row := make(map[string]float64, len(s.descs))
+		for i, name := range metricColumns {
+			switch name {
+			case "database":
+				key.database, _ = dbToString(rowData[i])
+			case "user":
+				key.user, _ = dbToString(rowData[i])
+			default:
+				if d, ok := s.descs[name]; ok {
+					if v, ok := dbToFloat64(rowData[i], d.factor); ok && !math.IsNaN(v) {
+						row[name] = v
+					}
+				}
+			}
+		}
+		if !s.filter.allowsDatabase(key.database) || !s.filter.allowsUser(key.user) {
+			continue
+		}
+		key.database = sanitize(key.database)
+		key.user = sanitize(s.users.anonymize(key.user))
+		values[key] = row
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating SHOW POOLS rows: %w", err)
+	}
+	return values, nil
+}
+
+// add stores a sample, dropping samples which fell out of the window.
+func (s *poolSampler) add(sample poolSample) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	cutoff := sample.at.Add(-s.config.Window)
+	i := 0
+	for i < len(s.samples) && s.samples[i].at.Before(cutoff) {
+		i++
+	}
+	s.samples = append(s.samples[i:], sample)
+}
+
+// Update implements Collector.
+func (s *poolSampler) Update(_ context.Context, ch chan<- prometheus.Metric, _ *sql.DB) error {
+	type aggregate struct {
+		max, min, sum float64
+		count         int
+	}
+	aggregates := make(map[poolKey]map[string]*aggregate)
+
+	s.mtx.Lock()
+	cutoff := s.now().Add(-s.config.Window)
+	for _, sample := range s.samples {
+		if sample.at.Before(cutoff) {
+			continue
+		}
+		for key, row := range sample.values {
+			if aggregates[key] == nil {
+				aggregates[key] = make(map[string]*aggregate)
+			}
+			for column, v := range row {
+				a, ok := aggregates[key][column]
+				if !ok {
+					aggregates[key][column] = &aggregate{max: v, min: v, sum: v, count: 1}
+					continue
+				}
+				a.max = math.Max(a.max, v)
+				a.min = math.Min(a.min, v)
+				a.sum += v
+				a.count++
+			}
+		}
+	}
+	s.mtx.Unlock()
+
+	for key, columns := range aggregates {
+		for column, a := range columns {
+			d := s.descs[column]
+			ch <- prometheus.MustNewConstMetric(d.max, prometheus.GaugeValue, a.max, key.database, key.user)
+			ch <- prometheus.MustNewConstMetric(d.min, prometheus.GaugeValue, a.min, key.database, key.user)
+			ch <- prometheus.MustNewConstMetric(d.mean, prometheus.GaugeValue, a.sum/float64(a.count), key.database, key.user)
+		}
+	}
+	return nil
+}
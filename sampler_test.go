@@ -0,0 +1,136 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPoolSampler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	columns := []string{"database", "user", "cl_waiting", "maxwait", "maxwait_us"}
+	for _, waiting := range []int64{2, 8, 5} {
+		mock.ExpectQuery("SHOW POOLS;").WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("pg0", "postgres", waiting, int64(1), int64(500000)))
+	}
+
+	config := PoolSamplerConfig{Interval: time.Second, Window: 30 * time.Second, Columns: []string{"cl_waiting", "maxwait"}}
+	descs, err := poolSamplerDescs(metricMaps, config.Columns)
+	if err != nil {
+		t.Fatalf("Error building sampler descriptors: %s", err)
+	}
+	version := func() bouncerVersion { return bouncerVersion{1, 24, 1} }
+	s := newPoolSampler(config, descs, LabelFilter{}, nil, version, db, slog.Default())
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+	for range 3 {
+		s.sample(context.Background())
+		now = now.Add(time.Second)
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	if err := s.Update(context.Background(), ch, db); err != nil {
+		t.Fatalf("Error updating sampler: %s", err)
+	}
+	close(ch)
+
+	expected := map[string]float64{
+		descs["cl_waiting"].max.String():  8,
+		descs["cl_waiting"].min.String():  2,
+		descs["cl_waiting"].mean.String(): 5,
+		descs["maxwait"].max.String():     1.5,
+		descs["maxwait"].min.String():     1.5,
+		descs["maxwait"].mean.String():    1.5,
+	}
+	convey.Convey("Samples are aggregated over the window", t, func() {
+		var count int
+		for m := range ch {
+			convey.So(readMetric(m), convey.ShouldResemble, MetricResult{
+				labels:     labelMap{"database": "pg0", "user": "postgres"},
+				value:      expected[m.Desc().String()],
+				metricType: dto.MetricType_GAUGE,
+			})
+			count++
+		}
+		convey.So(count, convey.ShouldEqual, 6)
+	})
+
+	convey.Convey("Samples outside the window are dropped", t, func() {
+		s.add(poolSample{at: now.Add(time.Minute)})
+		convey.So(s.samples, convey.ShouldHaveLength, 1)
+	})
+
+	convey.Convey("Only gauges of SHOW POOLS can be sampled", t, func() {
+		_, err := poolSamplerDescs(metricMaps, []string{"database"})
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = poolSamplerDescs(metricMaps, []string{"no_such_column"})
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPoolSamplerInvalidLabels(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW POOLS;").WillReturnRows(sqlmock.NewRows([]string{"database", "user", "cl_waiting"}).
+		AddRow("pg0\xff", "postgres\xff", int64(2)))
+
+	config := PoolSamplerConfig{Interval: time.Second, Window: 30 * time.Second, Columns: []string{"cl_waiting"}}
+	descs, err := poolSamplerDescs(metricMaps, config.Columns)
+	if err != nil {
+		t.Fatalf("Error building sampler descriptors: %s", err)
+	}
+	version := func() bouncerVersion { return bouncerVersion{} }
+	s := newPoolSampler(config, descs, LabelFilter{}, nil, version, db, slog.Default())
+	s.sample(context.Background())
+
+	ch := make(chan prometheus.Metric, 10)
+	if err := s.Update(context.Background(), ch, db); err != nil {
+		t.Fatalf("Error updating sampler: %s", err)
+	}
+	close(ch)
+
+	convey.Convey("Invalid UTF-8 labels are replaced", t, func() {
+		var count int
+		for m := range ch {
+			convey.So(readMetric(m).labels, convey.ShouldResemble, labelMap{"database": "<invalid>", "user": "<invalid>"})
+			count++
+		}
+		convey.So(count, convey.ShouldEqual, 3)
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeTimeoutHeader is set by Prometheus to the scrape timeout in seconds.
+const scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+
+// scrapeContext returns a context for the scrape requested by r. When
+// Prometheus announces its scrape timeout, the context expires offset before
+// it, so the exporter can still respond with the metrics collected so far.
+func scrapeContext(r *http.Request, offset time.Duration, logger *slog.Logger) (context.Context, context.CancelFunc) {
+	header := r.Header.Get(scrapeTimeoutHeader)
+	if header == "" {
+		return context.WithCancel(r.Context())
+	}
+
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil {
+		logger.Warn("Failed to parse scrape timeout header", "header", header, "err", err)
+		return context.WithCancel(r.Context())
+	}
+
+	timeout := time.Duration(seconds*float64(time.Second)) - offset
+	if timeout <= 0 {
+		logger.Warn("Scrape timeout offset exceeds the scrape timeout, ignoring it", "timeout_seconds", seconds, "offset", offset)
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// scrapeCollector runs a single scrape of the exporter bounded by ctx.
+//
+// It is registered to a per-request registry as an unchecked collector, as
+// describing the exporter would run an additional scrape.
+type scrapeCollector struct {
+	ctx      context.Context
+	exporter *Exporter
+}
+
+// Describe implements prometheus.Collector.
+func (c scrapeCollector) Describe(_ chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (c scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.exporter.collect(c.ctx, ch)
+}
+
+// handleMetrics returns the handler for the metrics path. It serves the
+// metrics of the default registry together with a scrape of exporter bounded
+// by the scrape timeout.
+func handleMetrics(exporter *Exporter, offset time.Duration, logger *slog.Logger) http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := scrapeContext(r, offset, logger)
+		defer cancel()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(scrapeCollector{ctx: ctx, exporter: exporter})
+
+		h := promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, registry}, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	}))
+}
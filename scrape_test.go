@@ -0,0 +1,316 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestScrapeContext(t *testing.T) {
+	logger := slog.Default()
+
+	convey.Convey("The scrape timeout header sets a deadline minus the offset", t, func() {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.Header.Set(scrapeTimeoutHeader, "10")
+		ctx, cancel := scrapeContext(r, 500*time.Millisecond, logger)
+		defer cancel()
+		deadline, ok := ctx.Deadline()
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(time.Until(deadline), convey.ShouldBeBetween, 9*time.Second, 9500*time.Millisecond)
+	})
+
+	convey.Convey("An offset larger than the timeout is ignored", t, func() {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.Header.Set(scrapeTimeoutHeader, "0.2")
+		ctx, cancel := scrapeContext(r, 500*time.Millisecond, logger)
+		defer cancel()
+		deadline, ok := ctx.Deadline()
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(time.Until(deadline), convey.ShouldBeGreaterThan, 0)
+	})
+
+	convey.Convey("Without the header there is no deadline", t, func() {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		ctx, cancel := scrapeContext(r, 500*time.Millisecond, logger)
+		defer cancel()
+		_, ok := ctx.Deadline()
+		convey.So(ok, convey.ShouldBeFalse)
+	})
+}
+
+func TestQueryCancelledByDeadline(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW LISTS;").WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"list", "items"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	ch := make(chan prometheus.Metric)
+	start := time.Now()
+	err = queryShowLists(ctx, ch, db, 0, slog.Default())
+
+	convey.Convey("Queries return once the deadline passes", t, func() {
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(errors.Is(ctx.Err(), context.DeadlineExceeded), convey.ShouldBeTrue)
+		convey.So(time.Since(start), convey.ShouldBeLessThan, time.Second)
+	})
+}
+
+func TestCollectorSuccess(t *testing.T) {
+	scrape := func(e *Exporter) (float64, map[string]float64) {
+		ch := make(chan prometheus.Metric, 100)
+		e.collect(context.Background(), ch)
+		close(ch)
+		var up float64
+		success := make(map[string]float64)
+		for m := range ch {
+			switch m.Desc() {
+			case scrapeSuccessDesc:
+				up = readMetric(m).value
+			case collectorSuccessDesc:
+				r := readMetric(m)
+				success[r.labels["collector"]] = r.value
+			}
+		}
+		return up, success
+	}
+
+	convey.Convey("A failing collector keeps up at 1", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Error opening a stub db connection: %s", err)
+		}
+		defer db.Close()
+
+		e := &Exporter{
+			conn:   newBackoffConnector(&fakeConnector{}, slog.Default()),
+			db:     db,
+			logger: slog.Default(),
+			collectors: map[string]Collector{
+				"lists": collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error {
+					return queryShowLists(ctx, ch, db, 0, slog.Default())
+				}),
+				"pools": collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error {
+					_, err := db.QueryContext(ctx, "SHOW POOLS;")
+					return err
+				}),
+			},
+		}
+
+		mock.ExpectQuery("SHOW VERSION;").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("PgBouncer 1.24.1"))
+		mock.ExpectQuery("SHOW LISTS;").WillReturnRows(sqlmock.NewRows([]string{"list", "items"}).AddRow("databases", 1))
+		mock.ExpectQuery("SHOW POOLS;").WillReturnError(errors.New("invalid command"))
+
+		up, success := scrape(e)
+		convey.So(up, convey.ShouldEqual, 1)
+		convey.So(success, convey.ShouldResemble, map[string]float64{"lists": 1, "pools": 0})
+		convey.So(mock.ExpectationsWereMet(), convey.ShouldBeNil)
+	})
+
+	convey.Convey("A lost connection sets up to 0", t, func() {
+		conn := newBackoffConnector(&fakeConnector{err: errors.New("connection refused")}, slog.Default())
+		db := sql.OpenDB(conn)
+		defer db.Close()
+
+		e := &Exporter{
+			conn:   conn,
+			db:     db,
+			logger: slog.Default(),
+			collectors: map[string]Collector{
+				"lists": collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric, db *sql.DB) error {
+					return queryShowLists(ctx, ch, db, 0, slog.Default())
+				}),
+			},
+		}
+
+		up, success := scrape(e)
+		convey.So(up, convey.ShouldEqual, 0)
+		convey.So(success, convey.ShouldBeEmpty)
+	})
+}
+
+func TestCoalescedScrape(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	var runs int
+	e := &Exporter{
+		conn:   newBackoffConnector(&fakeConnector{}, slog.Default()),
+		db:     db,
+		logger: slog.Default(),
+		collectors: map[string]Collector{
+			"test": collectorFunc(func(context.Context, chan<- prometheus.Metric, *sql.DB) error {
+				runs++
+				return nil
+			}),
+		},
+	}
+	WithCoalescing(time.Minute)(e)
+
+	scrapeShared := func() float64 {
+		ch := make(chan prometheus.Metric, 100)
+		e.collect(context.Background(), ch)
+		close(ch)
+		for m := range ch {
+			if m.Desc() == scrapeSharedDesc {
+				return readMetric(m).value
+			}
+		}
+		t.Fatal("missing scrape shared metric")
+		return 0
+	}
+
+	convey.Convey("Scrapes within the window reuse the last result", t, func() {
+		convey.So(scrapeShared(), convey.ShouldEqual, 0)
+		convey.So(scrapeShared(), convey.ShouldEqual, 1)
+		convey.So(runs, convey.ShouldEqual, 1)
+	})
+
+	convey.Convey("Scrapes after the window query again", t, func() {
+		e.sharedAt = e.sharedAt.Add(-time.Minute)
+		convey.So(scrapeShared(), convey.ShouldEqual, 0)
+		convey.So(runs, convey.ShouldEqual, 2)
+	})
+}
+
+func TestCoalescedScrapeLeader(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var runs int
+	e := &Exporter{
+		conn:   newBackoffConnector(&fakeConnector{}, slog.Default()),
+		db:     db,
+		logger: slog.Default(),
+		collectors: map[string]Collector{
+			"test": collectorFunc(func(context.Context, chan<- prometheus.Metric, *sql.DB) error {
+				runs++
+				close(started)
+				<-release
+				return nil
+			}),
+		},
+	}
+	WithCoalescing(time.Minute)(e)
+
+	scrapeShared := func(result *float64, wg *sync.WaitGroup) {
+		defer wg.Done()
+		ch := make(chan prometheus.Metric, 100)
+		e.collect(context.Background(), ch)
+		close(ch)
+		for m := range ch {
+			if m.Desc() == scrapeSharedDesc {
+				*result = readMetric(m).value
+			}
+		}
+	}
+
+	convey.Convey("Only callers joining a scrape in flight report it as shared", t, func() {
+		var leader, follower float64
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go scrapeShared(&leader, &wg)
+		<-started
+		go scrapeShared(&follower, &wg)
+		// Give the second caller time to join the scrape in flight.
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		convey.So(runs, convey.ShouldEqual, 1)
+		convey.So(leader, convey.ShouldEqual, 0)
+		convey.So(follower, convey.ShouldEqual, 1)
+	})
+}
+
+func TestCachedScrape(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	e := &Exporter{
+		conn:   newBackoffConnector(&fakeConnector{}, slog.Default()),
+		db:     db,
+		logger: slog.Default(),
+		collectors: map[string]Collector{
+			"version": collectorFunc(func(_ context.Context, ch chan<- prometheus.Metric, _ *sql.DB) error {
+				ch <- prometheus.MustNewConstMetric(bouncerVersionDesc, prometheus.GaugeValue, 1, "1.24.1")
+				return nil
+			}),
+		},
+	}
+	WithCache(time.Minute)(e)
+
+	scrape := func() map[*prometheus.Desc]float64 {
+		ch := make(chan prometheus.Metric, 100)
+		e.collect(context.Background(), ch)
+		close(ch)
+		values := make(map[*prometheus.Desc]float64)
+		for m := range ch {
+			values[m.Desc()] = readMetric(m).value
+		}
+		return values
+	}
+
+	convey.Convey("A successful scrape is served and remembered", t, func() {
+		values := scrape()
+		convey.So(values[scrapeSuccessDesc], convey.ShouldEqual, 1)
+		convey.So(values[scrapeCachedDesc], convey.ShouldEqual, 0)
+		convey.So(values, convey.ShouldContainKey, bouncerVersionDesc)
+		convey.So(values[lastSuccessfulScrapeDesc], convey.ShouldAlmostEqual, float64(e.cachedAt.UnixNano())/1e9)
+	})
+
+	convey.Convey("A failed scrape serves the last successful one", t, func() {
+		e.conn.lastErr = errors.New("connection refused")
+		values := scrape()
+		convey.So(values[scrapeSuccessDesc], convey.ShouldEqual, 0)
+		convey.So(values[scrapeCachedDesc], convey.ShouldEqual, 1)
+		convey.So(values, convey.ShouldContainKey, bouncerVersionDesc)
+	})
+
+	convey.Convey("Metrics older than the maximum age are not served", t, func() {
+		e.cachedAt = e.cachedAt.Add(-2 * time.Minute)
+		values := scrape()
+		convey.So(values[scrapeSuccessDesc], convey.ShouldEqual, 0)
+		convey.So(values[scrapeCachedDesc], convey.ShouldEqual, 0)
+		convey.So(values[lastSuccessfulScrapeDesc], convey.ShouldAlmostEqual, float64(e.cachedAt.UnixNano())/1e9)
+	})
+}
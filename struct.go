@@ -15,12 +15,15 @@ package main
 
 // Elasticsearch Node Stats Structs
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
-
-	"github.com/lib/pq"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
 )
 
 type columnUsage int
@@ -75,13 +78,40 @@ const (
 	COUNTER      columnUsage = iota // Use this column as a counter
 	GAUGE        columnUsage = iota // Use this column as a gauge
 	MAPPEDMETRIC columnUsage = iota // Use this column with the supplied mapping of text values
-	DURATION     columnUsage = iota // This column should be interpreted as a text duration (and converted to milliseconds)
+	DURATION     columnUsage = iota // This column should be interpreted as a text duration (and converted to seconds)
 )
 
 // Groups metric maps under a shared set of labels
 type MetricMapNamespace struct {
 	columnMappings map[string]MetricMap // Column mappings in this namespace
 	labels         []string
+	ratios         []RatioMap      // Metrics derived from two columns of a row
+	rewrites       []ColumnRewrite // Columns computed from other columns of a row
+}
+
+// Describes a metric derived by dividing two columns of the same row
+type RatioMapping struct {
+	numerator   string
+	denominator string
+	metric      string
+	description string
+}
+
+// Describes a column computed from other columns of the same row, for columns
+// renamed or split by a PgBouncer release. The value is the sum of the source
+// columns multiplied by their factor, in the unit of the column mapping.
+type ColumnRewrite struct {
+	versions versionRange
+	column   string
+	sources  []string
+	factors  []float64
+}
+
+// Stores the prometheus metric description of a derived ratio
+type RatioMap struct {
+	numerator   string
+	denominator string
+	desc        *prometheus.Desc
 }
 
 // Stores the prometheus metric description which a given column will be mapped
@@ -94,16 +124,76 @@ type MetricMap struct {
 }
 
 type ColumnMapping struct {
-	usage       columnUsage `yaml:"usage"`
-	metric      string      `yaml:"metric"`
-	factor      float64     `yaml:"factor"`
-	description string      `yaml:"description"`
+	usage       columnUsage        `yaml:"usage"`
+	metric      string             `yaml:"metric"`
+	factor      float64            `yaml:"factor"`
+	description string             `yaml:"description"`
+	mapping     map[string]float64 `yaml:"metric_mapping"` // For MAPPEDMETRIC: text value to metric value
+}
+
+// Implements the yaml.Unmarshaller interface. The factor defaults to 1 when
+// it is not given.
+func (cm *ColumnMapping) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value struct {
+		Usage       *columnUsage       `yaml:"usage"`
+		Metric      string             `yaml:"metric"`
+		Factor      *float64           `yaml:"factor"`
+		Description string             `yaml:"description"`
+		Mapping     map[string]float64 `yaml:"metric_mapping"`
+	}
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+
+	if value.Usage == nil {
+		return errors.New("missing usage")
+	}
+	*cm = ColumnMapping{
+		usage:       *value.Usage,
+		metric:      value.Metric,
+		factor:      1,
+		description: value.Description,
+		mapping:     value.Mapping,
+	}
+	if value.Factor != nil {
+		cm.factor = *value.Factor
+	}
+	return nil
 }
 
 // Exporter collects PgBouncer stats from the given server and exports
 // them using the prometheus metrics package.
 type Exporter struct {
-	conn      *pq.Connector
-	metricMap map[string]MetricMapNamespace
-	logger    *slog.Logger
+	conn           *backoffConnector
+	db             *sql.DB
+	columnMappings map[string]map[string]ColumnMapping
+	metricMap      map[string]MetricMapNamespace
+	collectors     map[string]Collector
+	logger         *slog.Logger
+
+	// Scrape coalescing, see WithCoalescing.
+	coalesceWindow time.Duration
+	scrapeGroup    singleflight.Group
+	sharedMtx      sync.Mutex
+	sharedMetrics  []prometheus.Metric
+	sharedAt       time.Time
+
+	// Last successful scrape, see WithCache.
+	cacheMaxAge   time.Duration
+	cacheMtx      sync.Mutex
+	cachedMetrics []prometheus.Metric
+	cachedAt      time.Time
+
+	samplerConfig PoolSamplerConfig
+	sampler       *poolSampler
+
+	clientOptions ClientOptions
+	filter        LabelFilter
+	users         *UserAnonymizer
+
+	// PgBouncer version, see detectVersion.
+	versionMtx        sync.Mutex
+	version           bouncerVersion
+	versionOutput     string
+	versionReconnects float64
 }
@@ -0,0 +1,197 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// bouncerVersion is a PgBouncer release. The zero value is an unknown version.
+type bouncerVersion struct {
+	major, minor, patch int
+}
+
+var bouncerVersionRegexp = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseBouncerVersion parses the output of SHOW VERSION, such as
+// "PgBouncer 1.24.1" or "PgBouncer 1.8.1/bouncer".
+func parseBouncerVersion(s string) (bouncerVersion, error) {
+	m := bouncerVersionRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return bouncerVersion{}, fmt.Errorf("no version number in %q", s)
+	}
+	var v bouncerVersion
+	v.major, _ = strconv.Atoi(m[1])
+	v.minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.patch, _ = strconv.Atoi(m[3])
+	}
+	return v, nil
+}
+
+func (v bouncerVersion) known() bool {
+	return v != bouncerVersion{}
+}
+
+// before returns whether v is an older release than o.
+func (v bouncerVersion) before(o bouncerVersion) bool {
+	if v.major != o.major {
+		return v.major < o.major
+	}
+	if v.minor != o.minor {
+		return v.minor < o.minor
+	}
+	return v.patch < o.patch
+}
+
+func (v bouncerVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+}
+
+// versionRange holds the releases from since up to, but excluding, until.
+// Unset bounds are open.
+type versionRange struct {
+	since, until bouncerVersion
+}
+
+// contains returns whether v is in the range. An unknown version is in every
+// range, leaving the decision to the columns PgBouncer returns.
+func (r versionRange) contains(v bouncerVersion) bool {
+	if !v.known() {
+		return true
+	}
+	if r.since.known() && v.before(r.since) {
+		return false
+	}
+	return !r.until.known() || v.before(r.until)
+}
+
+// rewriteColumns returns the rewrites of a query applying to the version and
+// the returned columns, and the column each index of the row is mapped to,
+// empty for source columns replaced by a rewrite.
+func rewriteColumns(rewrites []ColumnRewrite, version bouncerVersion, columnNames []string, columnIdx map[string]int) ([]ColumnRewrite, []int, []string) {
+	metricColumns := append([]string(nil), columnNames...)
+	var applied []ColumnRewrite
+	var targets []int
+rewrites:
+	for _, r := range rewrites {
+		if !r.versions.contains(version) {
+			continue
+		}
+		for _, source := range r.sources {
+			if _, ok := columnIdx[source]; !ok {
+				continue rewrites
+			}
+		}
+		for _, source := range r.sources {
+			metricColumns[columnIdx[source]] = ""
+		}
+		target, ok := columnIdx[r.column]
+		if !ok {
+			target = columnIdx[r.sources[0]]
+		}
+		metricColumns[target] = r.column
+		applied = append(applied, r)
+		targets = append(targets, target)
+	}
+	return applied, targets, metricColumns
+}
+
+// combine returns the value of the rewritten column of a row. It is NaN if a
+// source column is NULL.
+func (r ColumnRewrite) combine(columnData []interface{}, columnIdx map[string]int) (float64, bool) {
+	var sum float64
+	for i, source := range r.sources {
+		v, ok := dbToFloat64(columnData[columnIdx[source]], r.factors[i])
+		if !ok {
+			return 0, false
+		}
+		sum += v
+	}
+	return sum, true
+}
+
+// queryBouncerVersion returns the output of SHOW VERSION.
+func queryBouncerVersion(ctx context.Context, db *sql.DB) (string, error) {
+	rows, err := db.QueryContext(ctx, "SHOW VERSION;")
+	if err != nil {
+		return "", fmt.Errorf("error getting pgbouncer version: %w", err)
+	}
+	defer rows.Close()
+
+	columnNames, err := rows.Columns()
+	if err != nil {
+		return "", fmt.Errorf("error retrieving column list for version: %w", err)
+	}
+	if len(columnNames) != 1 || columnNames[0] != "version" {
+		return "", errors.New("show version didn't return version column")
+	}
+
+	var version string
+	for rows.Next() {
+		if err := rows.Scan(&version); err != nil {
+			return "", err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// detectVersion returns the output of SHOW VERSION and parses the version of
+// PgBouncer for detectedVersion. It queries PgBouncer once per connection,
+// since an upgrade restarts PgBouncer and breaks the connection. After an
+// error it queries again on the next call. The version is not locked during
+// the query, so that users of the connection can read it meanwhile.
+func (e *Exporter) detectVersion(ctx context.Context) (string, error) {
+	reconnects, _, _ := e.conn.stats()
+
+	e.versionMtx.Lock()
+	output, cached := e.versionOutput, e.versionOutput != "" && e.versionReconnects == reconnects
+	e.versionMtx.Unlock()
+	if cached {
+		return output, nil
+	}
+
+	s, err := queryBouncerVersion(ctx, e.db)
+
+	e.versionMtx.Lock()
+	defer e.versionMtx.Unlock()
+	if err != nil {
+		e.version, e.versionOutput = bouncerVersion{}, ""
+		return "", err
+	}
+	v, err := parseBouncerVersion(s)
+	if err != nil {
+		e.logger.Debug("error parsing pgbouncer version", "err", err.Error())
+	} else {
+		e.logger.Debug("detected pgbouncer version", "version", v.String())
+	}
+	e.version, e.versionOutput, e.versionReconnects = v, s, reconnects
+	return s, nil
+}
+
+// detectedVersion returns the version of PgBouncer last detected, which is
+// unknown until a scrape detected it.
+func (e *Exporter) detectedVersion() bouncerVersion {
+	e.versionMtx.Lock()
+	defer e.versionMtx.Unlock()
+	return e.version
+}
@@ -0,0 +1,232 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseBouncerVersion(t *testing.T) {
+	convey.Convey("Versions are parsed from SHOW VERSION", t, func() {
+		for s, expected := range map[string]bouncerVersion{
+			"PgBouncer 1.24.1":         {1, 24, 1},
+			"PgBouncer 1.8.1/bouncer":  {1, 8, 1},
+			"PgBouncer 1.12":           {1, 12, 0},
+			"PgBouncer 1.23.0 (debug)": {1, 23, 0},
+		} {
+			v, err := parseBouncerVersion(s)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(v, convey.ShouldResemble, expected)
+		}
+	})
+
+	convey.Convey("Output without a version number is an error", t, func() {
+		_, err := parseBouncerVersion("PgBouncer")
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestVersionRange(t *testing.T) {
+	r := versionRange{since: bouncerVersion{1, 8, 0}, until: bouncerVersion{1, 24, 0}}
+
+	convey.Convey("Ranges include since and exclude until", t, func() {
+		convey.So(r.contains(bouncerVersion{1, 7, 2}), convey.ShouldBeFalse)
+		convey.So(r.contains(bouncerVersion{1, 8, 0}), convey.ShouldBeTrue)
+		convey.So(r.contains(bouncerVersion{1, 23, 1}), convey.ShouldBeTrue)
+		convey.So(r.contains(bouncerVersion{1, 24, 0}), convey.ShouldBeFalse)
+	})
+
+	convey.Convey("Unset bounds and unknown versions match", t, func() {
+		convey.So(versionRange{}.contains(bouncerVersion{1, 24, 1}), convey.ShouldBeTrue)
+		convey.So(r.contains(bouncerVersion{}), convey.ShouldBeTrue)
+	})
+}
+
+// queryRewrittenNamespace returns all metrics of a namespace query.
+func queryRewrittenNamespace(t *testing.T, metricMap map[string]MetricMapNamespace, ns string, version bouncerVersion, rows *sqlmock.Rows) map[string]float64 {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW " + ns + ";").WillReturnRows(rows)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if _, err := queryNamespaceMapping(context.Background(), ch, db, ns, metricMap[ns], version, LabelFilter{}, nil, slog.Default()); err != nil {
+			t.Errorf("Error running queryNamespaceMapping: %s", err)
+		}
+	}()
+
+	values := make(map[string]float64)
+	for m := range ch {
+		pb := &dto.Metric{}
+		m.Write(pb)
+		values[m.Desc().String()] = pb.GetGauge().GetValue()
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled exceptions: %s", err)
+	}
+	return values
+}
+
+func TestColumnRewrites(t *testing.T) {
+	metricMap := makeDescMap(metricMaps, namespace, slog.Default())
+	reservePool := metricMap["databases"].columnMappings["reserve_pool"].desc.String()
+	maxwait := metricMap["pools"].columnMappings["maxwait"].desc.String()
+
+	databaseRows := func(column string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"name", "database", column}).AddRow("pg0_db", "pg0", 5)
+	}
+
+	convey.Convey("Renamed columns are aliased from the release renaming them", t, func() {
+		values := queryRewrittenNamespace(t, metricMap, "databases", bouncerVersion{1, 24, 1}, databaseRows("reserve_pool_size"))
+		convey.So(values, convey.ShouldResemble, map[string]float64{reservePool: 5})
+
+		values = queryRewrittenNamespace(t, metricMap, "databases", bouncerVersion{1, 23, 1}, databaseRows("reserve_pool"))
+		convey.So(values, convey.ShouldResemble, map[string]float64{reservePool: 5})
+
+		values = queryRewrittenNamespace(t, metricMap, "databases", bouncerVersion{1, 23, 1}, databaseRows("reserve_pool_size"))
+		convey.So(values, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Without a version the returned columns decide", t, func() {
+		values := queryRewrittenNamespace(t, metricMap, "databases", bouncerVersion{}, databaseRows("reserve_pool_size"))
+		convey.So(values, convey.ShouldResemble, map[string]float64{reservePool: 5})
+	})
+
+	convey.Convey("Split columns are combined into one value", t, func() {
+		rows := sqlmock.NewRows([]string{"database", "user", "maxwait", "maxwait_us"}).AddRow("pg0", "postgres", 2, 250000)
+		values := queryRewrittenNamespace(t, metricMap, "pools", bouncerVersion{1, 24, 1}, rows)
+		convey.So(values, convey.ShouldResemble, map[string]float64{maxwait: 2.25})
+
+		rows = sqlmock.NewRows([]string{"database", "user", "maxwait"}).AddRow("pg0", "postgres", 2)
+		values = queryRewrittenNamespace(t, metricMap, "pools", bouncerVersion{1, 24, 1}, rows)
+		convey.So(values, convey.ShouldResemble, map[string]float64{maxwait: 2})
+	})
+}
+
+func TestDetectVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	e := &Exporter{
+		conn:   newBackoffConnector(&fakeConnector{}, slog.Default()),
+		db:     db,
+		logger: slog.Default(),
+	}
+
+	convey.Convey("The version is queried once per connection", t, func() {
+		mock.ExpectQuery("SHOW VERSION;").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("PgBouncer 1.23.1"))
+		for range 2 {
+			version, err := e.detectVersion(context.Background())
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(version, convey.ShouldEqual, "PgBouncer 1.23.1")
+			convey.So(e.detectedVersion(), convey.ShouldResemble, bouncerVersion{1, 23, 1})
+		}
+		convey.So(mock.ExpectationsWereMet(), convey.ShouldBeNil)
+
+		e.conn.reconnects++
+		mock.ExpectQuery("SHOW VERSION;").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("PgBouncer 1.24.1"))
+		_, err := e.detectVersion(context.Background())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(e.detectedVersion(), convey.ShouldResemble, bouncerVersion{1, 24, 1})
+		convey.So(mock.ExpectationsWereMet(), convey.ShouldBeNil)
+	})
+
+	convey.Convey("An unparsable version is kept unknown for the connection", t, func() {
+		e.conn.reconnects++
+		mock.ExpectQuery("SHOW VERSION;").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("unknown"))
+		for range 2 {
+			version, err := e.detectVersion(context.Background())
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(version, convey.ShouldEqual, "unknown")
+			convey.So(e.detectedVersion().known(), convey.ShouldBeFalse)
+		}
+		convey.So(mock.ExpectationsWereMet(), convey.ShouldBeNil)
+	})
+
+	convey.Convey("The version can be read while it is queried", t, func() {
+		e.conn.reconnects++
+		mock.ExpectQuery("SHOW VERSION;").WillDelayFor(200 * time.Millisecond).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("PgBouncer 1.24.1"))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			e.detectVersion(context.Background())
+		}()
+		time.Sleep(20 * time.Millisecond)
+		start := time.Now()
+		e.detectedVersion()
+		convey.So(time.Since(start), convey.ShouldBeLessThan, 100*time.Millisecond)
+		<-done
+		convey.So(mock.ExpectationsWereMet(), convey.ShouldBeNil)
+	})
+
+	convey.Convey("A failed query is tried again", t, func() {
+		e.conn.reconnects++
+		mock.ExpectQuery("SHOW VERSION;").WillReturnError(errors.New("connection reset"))
+		_, err := e.detectVersion(context.Background())
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(e.detectedVersion().known(), convey.ShouldBeFalse)
+
+		mock.ExpectQuery("SHOW VERSION;").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("PgBouncer 1.24.1"))
+		_, err = e.detectVersion(context.Background())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(e.detectedVersion(), convey.ShouldResemble, bouncerVersion{1, 24, 1})
+		convey.So(mock.ExpectationsWereMet(), convey.ShouldBeNil)
+	})
+}
+
+func TestVersionCollector(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error opening a stub db connection: %s", err)
+	}
+	defer db.Close()
+
+	e := &Exporter{
+		conn:   newBackoffConnector(&fakeConnector{}, slog.Default()),
+		db:     db,
+		logger: slog.Default(),
+	}
+	version := factories["version"](e)
+
+	convey.Convey("The version collector reuses the detected version", t, func() {
+		mock.ExpectQuery("SHOW VERSION;").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("PgBouncer 1.24.1"))
+		_, err := e.detectVersion(context.Background())
+		convey.So(err, convey.ShouldBeNil)
+
+		ch := make(chan prometheus.Metric, 1)
+		convey.So(version.Update(context.Background(), ch, db), convey.ShouldBeNil)
+		close(ch)
+		m := readMetric(<-ch)
+		convey.So(m.labels, convey.ShouldResemble, labelMap{"version": "PgBouncer 1.24.1"})
+		convey.So(mock.ExpectationsWereMet(), convey.ShouldBeNil)
+	})
+}